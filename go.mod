@@ -2,11 +2,16 @@ module nostk
 
 go 1.20
 
-require github.com/nbd-wtf/go-nostr v0.19.3
+require (
+	github.com/btcsuite/btcd/btcutil v1.1.3
+	github.com/nbd-wtf/go-nostr v0.19.3
+	golang.org/x/crypto v0.21.0
+	golang.org/x/term v0.18.0
+	golang.org/x/text v0.14.0
+)
 
 require (
 	github.com/btcsuite/btcd/btcec/v2 v2.2.0 // indirect
-	github.com/btcsuite/btcd/btcutil v1.1.3 // indirect
 	github.com/btcsuite/btcd/chaincfg/chainhash v1.0.1 // indirect
 	github.com/decred/dcrd/crypto/blake256 v1.0.0 // indirect
 	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.0.1 // indirect
@@ -20,5 +25,5 @@ require (
 	github.com/tidwall/match v1.1.1 // indirect
 	github.com/tidwall/pretty v1.2.0 // indirect
 	golang.org/x/exp v0.0.0-20221106115401-f9659909a136 // indirect
-	golang.org/x/sys v0.6.0 // indirect
+	golang.org/x/sys v0.18.0 // indirect
 )
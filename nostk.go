@@ -2,18 +2,44 @@ package main
 
 import (
 	"os"
+	"bytes"
 	"time"
 	"os/exec"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"strings"
 	"bufio"
 	"io/ioutil"
 	"context"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"html"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"regexp"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"sync"
+	"github.com/btcsuite/btcd/btcutil/bech32"
 	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip10"
 	"github.com/nbd-wtf/go-nostr/nip19"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+	"golang.org/x/text/unicode/norm"
 )
 
 const (
@@ -23,6 +49,15 @@ const (
 	hpub	= ".hpub"
 	npub	= ".npub"
 	relays	= "relays.json"
+	draftRelays	= "draft_relays.json"
+	drafts	= "drafts"
+	pending	= "pending.ndjson"
+	rejected	= "rejected.ndjson"
+	health	= "relay_health.json"
+	relayListCache	= "relay_lists.json"
+	lastEventFile	= "last_event.json"
+	profileTime	= "profile_created_at"
+	threadChain	= "thread_chain.json"
 	profile	= "profile.json"
 	emoji	= "customemoji.json"
 )
@@ -39,14 +74,77 @@ type ProfileMetadata struct {
 }
 
 type RwFlag struct {
-	Read  bool `json:"read"`
-	Write bool `json:"write"`
+	Read   bool     `json:"read"`
+	Write  bool     `json:"write"`
+	Topics []string `json:"topics,omitempty"`
 }
 
+type Draft struct {
+	Content string     `json:"content"`
+	Tags    nostr.Tags `json:"tags"`
+	SavedAt int64      `json:"saved_at"`
+}
+
+// LastEvent is what lastEvent shows of the event published most recently.
+// The signature is left out on purpose.
+type LastEvent struct {
+	ID        string          `json:"id"`
+	Kind      int             `json:"kind"`
+	CreatedAt nostr.Timestamp `json:"created_at"`
+	Tags      nostr.Tags      `json:"tags"`
+	Content   string          `json:"content"`
+}
+
+type CachedRelayList struct {
+	Relays    []string `json:"relays"`
+	FetchedAt int64    `json:"fetched_at"`
+}
+
+type PendingEvent struct {
+	Relay  string      `json:"relay"`
+	Event  nostr.Event `json:"event"`
+	Reason string      `json:"reason,omitempty"`
+}
+
+type RelayHealth struct {
+	Success   int    `json:"success"`
+	Failure   int    `json:"failure"`
+	LatencyMs int64  `json:"latency_ms"` // sum over successful requests
+	LastError string `json:"last_error,omitempty"`
+	LastSeen  int64  `json:"last_seen"`
+}
+
+// global options, set by parseGlobalOptions
+var (
+	signAs        string
+	traceMode     bool
+	draftMode     bool
+	quietSuccess  bool
+	dumpUnsigned  bool
+	escapeUnicode bool
+	limitContent  int
+	concurrency   = 4
+	jsonIndent    = "  "
+	fixClock      bool
+	verifyPublish bool
+	discoverRelay bool
+	publishTopic  string
+	wireDump      bool
+	relayTimeout  = 10 * time.Second
+	onlyRelay     string
+	maxContent    = 64 * 1024
+	maxTags       = 2000
+	allowSecret   bool
+	profileName   string
+)
+
 /*
 main {{{
 */
 func main() {
+	if err := parseGlobalOptions(); err != nil {
+		fatal(err)
+	}
 	if len(os.Args) < 2 {
 		dispHelp()
 		os.Exit(0)
@@ -60,56 +158,352 @@ func main() {
 		dispHelp()
 	case "init":
 		if err := initEnv(); err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 	case "genkey":
-		if err := genKey(); err != nil {
-			log.Fatal(err)
+		if err := genKey(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "encryptKey":
+		if err := encryptKey(); err != nil {
+			fatal(err)
+		}
+	case "importKey":
+		if err := importKey(os.Args[2:]); err != nil {
+			fatal(err)
 		}
 	case "lsRelays":
-		if err := listRelays(); err != nil {
-			log.Fatal(err)
+		if err := listRelays(os.Args[2:]); err != nil {
+			fatal(err)
 		}
 	case "editRelays":
-		if err := editRelayList(); err != nil {
-			log.Fatal(err)
+		if len(os.Args) > 2 && os.Args[2] == "--append" {
+			if len(os.Args) < 4 {
+				log.Fatal(errors.New("Not set relay URL"))
+			}
+			if err := appendRelayList(os.Args[3]); err != nil {
+				fatal(err)
+			}
+		} else if err := editRelayList(); err != nil {
+			fatal(err)
+		}
+	case "addRelay":
+		if err := addRelay(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "removeRelay":
+		if err := removeRelay(os.Args[2:]); err != nil {
+			fatal(err)
 		}
 	case "editProfile":
 		if err := editProfile(); err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 	case "editEmoji":
 		if err := editCustomEmojiList(); err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 	case "pubProfile":
-		if err := publishProfile(); err != nil {
-			log.Fatal(err)
+		if err := publishProfile(os.Args[2:]); err != nil {
+			fatal(err)
 		}
 	case "pubRelays":
 		if err := publishRelayList(); err != nil {
-			log.Fatal(err)
+			fatal(err)
+		}
+	case "archive":
+		if err := archiveNotes(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "reactions":
+		if err := reactionSummary(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "zapTotal":
+		if err := zapTotal(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "delEvent":
+		if err := delEvent(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "deleteEvent":
+		if err := deleteEvent(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "reaction":
+		if err := publishReaction(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "repost":
+		if err := publishRepost(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "lsMyReactions":
+		if err := listMyReactions(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "unreact":
+		if err := unreact(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "stats":
+		if err := showStats(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "selftest":
+		if err := selfTest(); err != nil {
+			fatal(err)
+		}
+	case "flush":
+		if err := flushPending(); err != nil {
+			fatal(err)
+		}
+	case "getEmojiSet":
+		if err := getEmojiSet(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "relayHealth":
+		if err := showRelayHealth(); err != nil {
+			fatal(err)
+		}
+	case "encode":
+		if err := encodeEntity(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "decode":
+		if err := decodeEntity(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "convert":
+		if err := convertKeys(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "getAddr":
+		if err := getAddr(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "defineBadge":
+		if err := defineBadge(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "awardBadge":
+		if err := awardBadge(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "timeline":
+		if err := showTimeline(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "outboxTimeline":
+		if err := outboxTimeline(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "doctor":
+		if err := doctor(); err != nil {
+			fatal(err)
+		}
+	case "getProfile":
+		if err := getProfile(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "pullProfile":
+		if err := pullProfile(); err != nil {
+			fatal(err)
+		}
+	case "follow":
+		if err := follow(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "unfollow":
+		if err := unfollow(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "lsFollows":
+		if err := listFollows(); err != nil {
+			fatal(err)
+		}
+	case "exportFollows":
+		if err := exportFollows(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "active":
+		if err := showActive(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "thread":
+		if err := showThread(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "topic":
+		if err := showTopic(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "checkMyNip05", "verifyNip05":
+		if err := checkMyNip05(); err != nil {
+			fatal(err)
+		}
+	case "nevent":
+		if err := encodeNevent(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "followDiff":
+		if err := followDiff(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "lastEvent":
+		if err := showLastEvent(); err != nil {
+			fatal(err)
+		}
+	case "mentionsOfUrl":
+		if err := mentionsOfURL(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "propagation":
+		if err := measurePropagation(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "pullRelays":
+		if err := pullRelayList(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "purgeFromRelay":
+		if err := purgeFromRelay(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "syncReplaceables":
+		if err := syncReplaceables(); err != nil {
+			fatal(err)
+		}
+	case "draft":
+		if err := draftCommand(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "threadPost":
+		if err := threadPost(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "pubReply":
+		if err := publishReply(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+	case "pubMessageTo":
+		if err := publishMessageTo(os.Args[2:]); err != nil {
+			fatal(err)
 		}
 	case "pubMessage":
-		if len(os.Args) > 2 {
-			if err := publishMessage(os.Args[2]); err != nil {
-				log.Fatal(err)
-				os.Exit(1)
-			}
-		} else {
-			buff, err := readStdIn()
-			if err!=nil {
-				fmt.Println("Nothing text message.")
-				log.Fatal(errors.New("Not set text message"))
-				os.Exit(1)
-			}
-			if err := publishMessage(buff); err != nil {
-				log.Fatal(err)
-				os.Exit(1)
-			}
+		args := os.Args[2:]
+		tgs := nostr.Tags{}
+		if err := setMessageOptions(&args, &tgs); err != nil {
+			fatal(err)
+		}
+		buff, err := readMessage(&args)
+		if err != nil {
+			fatal(err)
+		}
+		if _, err := publishMessage(buff, tgs); err != nil {
+			fatal(err)
+		}
+	}
+	if discoverRelay {
+		showDiscoveredRelays()
+	}
+}
+// }}}
+
+/*
+fatal {{{
+*/
+// fatal ends the command on err, except that --dump-unsigned stopping
+// before signing is a success.
+func fatal(err error) {
+	if errors.Is(err, errDumpedUnsigned) {
+		os.Exit(0)
+	}
+	log.Fatal(err)
+}
+
+// }}}
+
+/*
+parseGlobalOptions {{{
+*/
+func parseGlobalOptions() error {
+	args := os.Args[1:]
+	signAs, _ = getOption(&args, "--sign-as")
+	traceMode = hasOption(&args, "--trace")
+	draftMode = hasOption(&args, "--draft")
+	quietSuccess = hasOption(&args, "--quiet-success")
+	dumpUnsigned = hasOption(&args, "--dump-unsigned")
+	escapeUnicode = hasOption(&args, "--escape-unicode")
+	fixClock = hasOption(&args, "--fix-clock")
+	verifyPublish = hasOption(&args, "--verify-publish")
+	discoverRelay = hasOption(&args, "--discover-relays")
+	publishTopic, _ = getOption(&args, "--topic")
+	wireDump = hasOption(&args, "--wire-dump")
+	allowSecret = hasOption(&args, "--allow-secret")
+	profileName = os.Getenv("NOSTK_PROFILE")
+	if n, ok := getOption(&args, "--profile"); ok {
+		profileName = n
+	}
+	if profileName != "" && (profileName == "." || profileName == ".." || strings.ContainsAny(profileName, "/\\")) {
+		return errors.New("Invalid profile name: " + profileName)
+	}
+	if u, ok := getOption(&args, "--only-relay"); ok {
+		if !isRelayURL(u) {
+			return errors.New("Invalid relay URL: " + u)
+		}
+		onlyRelay = normalizeRelayURL(u)
+	}
+	if l, ok := getOption(&args, "--limit-content"); ok {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 {
+			return errors.New("Invalid content limit: " + l)
+		}
+		limitContent = n
+	}
+	if i, ok := getOption(&args, "--indent"); ok {
+		n, err := strconv.Atoi(i)
+		if err != nil || n < 0 {
+			return errors.New("Invalid indent: " + i)
+		}
+		jsonIndent = strings.Repeat(" ", n)
+	}
+	if hasOption(&args, "--compact") {
+		jsonIndent = ""
+	}
+	if m, ok := getOption(&args, "--max-content"); ok {
+		n, err := strconv.Atoi(m)
+		if err != nil || n < 1 {
+			return errors.New("Invalid content size: " + m)
 		}
+		maxContent = n
 	}
+	if m, ok := getOption(&args, "--max-tags"); ok {
+		n, err := strconv.Atoi(m)
+		if err != nil || n < 0 {
+			return errors.New("Invalid tag count: " + m)
+		}
+		maxTags = n
+	}
+	if c, ok := getOption(&args, "--concurrency"); ok {
+		n, err := strconv.Atoi(c)
+		if err != nil || n < 1 {
+			return errors.New("Invalid concurrency: " + c)
+		}
+		concurrency = n
+	}
+	if t, ok := getOption(&args, "--timeout"); ok {
+		n, err := strconv.Atoi(t)
+		if err != nil || n < 1 {
+			return errors.New("Invalid timeout: " + t)
+		}
+		relayTimeout = time.Duration(n) * time.Second
+	}
+	os.Args = append(os.Args[:1:1], args...)
+	return nil
 }
+
 // }}}
 
 /*
@@ -117,30 +511,170 @@ dispHelp {{{
 */
 func dispHelp() {
 	const (
-		usage				= "Usage :\n  nostk <sub-command> [param...]"
+		usage				= "Usage :\n  nostk [global-option...] <sub-command> [param...]"
+		globalOption		= "    global-option :"
+		strSignAs			= "        --sign-as <npub> : Abort publishing unless your key matches npub."
+		strTrace			= "        --trace : Log relay connection steps to stderr."
+		strDraft			= "        --draft : Publish only to the relays in draft_relays.json."
+		strQuietSuccess		= "        --quiet-success : Report only relays that failed, plus a summary."
+		strDumpUnsigned		= "        --dump-unsigned : Print the event before signing and exit."
+		strEscapeUnicode	= "        --escape-unicode : Show non-ASCII characters in notes as \\uXXXX."
+		strLimitContent		= "        --limit-content <n> : Cut notes in listings after n characters."
+		strWireDump			= "        --wire-dump : Print the EVENT and OK frames exchanged with each relay."
+		strOnlyRelay		= "        --only-relay <url> : Read from and publish to this relay only, ignoring relays.json."
+		strPublishTopic		= "        --topic <name> : Publish only to the relays with the topic in relays.json."
+		strDiscoverRelays	= "        --discover-relays : Suggest relays found in the events that were read."
+		strVerifyPublish	= "        --verify-publish : Fetch the event back from each relay after publishing."
+		strFixClock			= "        --fix-clock : Correct created_at by the clock of the first relay."
+		strIndent			= "        --indent <n> | --compact : Indent JSON output by n spaces (default 2), or print it on one line."
+		strMaxContent		= "        --max-content <n> | --max-tags <n> : Refuse to sign events with more content bytes (default 65536) or tags (default 2000)."
+		strAllowSecret		= "        --allow-secret : Publish even if the content looks like a private key."
+		strProfile			= "        --profile <name> : Use the keys and settings of the named profile (also NOSTK_PROFILE)."
+		strTimeout			= "        --timeout <sec> : Skip a relay that does not answer in time (default 10)."
+		strConcurrency		= "        --concurrency <n> : Number of relays read at the same time (default 4)."
 		subcommand			= "    sub-command :"
 		strInit				= "        init : Initializing the nostk environment"
-		genkey				= "        genkey : create Prive Key and Public Key"
-		strListRelay		= "        lsRelay : Show relay list"
+		genkey				= "        genkey [--encrypt] : create Prive Key and Public Key"
+		strImportKey		= "        importKey <nsec|hex> [--force] [--encrypt] : Use an existing private key."
+		strEncryptKey		= "        encryptKey : Encrypt the stored private key with a passphrase (NIP-49)."
+		strListRelay		= "        lsRelays [--json] : Show relay list"
 		strEditRelay		= "        editRelays : edit relay list."
+		strAppendRelay		= "        editRelays --append <url>[,<url>...] : Add relays to relay list."
+		strAddRelay			= "        addRelay <url> [--read] [--write] : Add a relay, or change its flags. Both are set without flags."
+		strRemoveRelay		= "        removeRelay <url> : Remove a relay from relay list."
 		strPubRelay			= "        pubRelays : Publish relay list."
 		strEditProfile		= "        editProfile : Edit your profile."
 		strCustomEmoji		= "        editEmoji : Edit custom emoji list."
-		strPublishProfile	= "        pubProfile: Publish your profile."
-		strPublishMessage	= "        pubMessage <text message>: Publish message to relays."
+		strPublishProfile	= "        pubProfile [--file <path>] [--verify-nip05] : Publish your profile, or the one in the file."
+		strPublishMessage	= "        pubMessage [--alt <text>] [--cw [reason]] [--geohash <hash>|--location <lat,lon>] [--imeta <url>...] [--imeta-fetch] <text message>|-|--file <path> : Publish message to relays, reading it from stdin with -."
+		strPublishMessageTo	= "        pubMessageTo <npub|hex> [option...] <text message>|--file <path> : Publish a note tagging the user, with the options of pubMessage."
+		strPublishReply		= "        pubReply <id> [option...] <text message>|--file <path> : Reply to the note, with the options of pubMessage."
+		strThreadPost		= "        threadPost <root-id> [option...] <text message>|--file <path> : Add a note to your thread of posts under the root."
+		strArchive			= "        archive <dir> [--format md|html] [--long-form] : Save your notes as files."
+		strDelEvent			= "        delEvent <id> [reason] : Request deletion of one of your events."
+		strDeleteEvent		= "        deleteEvent <id>... [--from-file <path>] [--reason <text>] : Request deletion of your events."
+		strReaction			= "        reaction <id> [<emoji>|:<shortcode>:] : React to the note, with + by default."
+		strRepost			= "        repost <id> [--no-embed] : Repost the note, embedding it unless --no-embed is given."
+		strLsMyReactions	= "        lsMyReactions [count] : Show your reactions and the notes they are for."
+		strUnreact			= "        unreact <id> : Delete your reactions to the note."
+		strReactions		= "        reactions [npub|name@domain] [--emoji-only] [--limit <n>] : Summarize reactions to notes."
+		strZapTotal			= "        zapTotal <id> : Sum the zaps the note received, with the top zappers."
+		strStats			= "        stats [npub|name@domain] : Show follow count and follower estimate."
+		strSelfTest			= "        selftest : Check your key pair offline."
+		strFlush			= "        flush : Retry publishing events that failed before. Events relays refused are moved to rejected.ndjson."
+		strConvert			= "        convert <file|-> [--to hex|npub|note|nevent] : Convert keys and ids line by line."
+		strEncode			= "        encode <npub|nsec|note|nevent|nprofile> <hex> [relay...] [--author <hex>] : Encode a key or id in NIP-19."
+		strDecode			= "        decode <bech32> : Show the prefix and contents of a NIP-19 entity."
+		strGetAddr			= "        getAddr <naddr> : Show an addressable event."
+		strDefineBadge		= "        defineBadge <identifier> <name> <image-url> : Publish a badge definition."
+		strAwardBadge		= "        awardBadge <badge-naddr> <npub>... : Award a badge to users."
+		strDraftCmd			= "        draft save <name> [<text>|--file <path>]|list|show <name>|publish <name>|rm <name> : Manage local drafts."
+		strTimeline			= "        timeline [--limit <n>] [--author <npub>] [--no-replies|--replies-only] : Read notes from your read relays."
+		strOutboxTimeline	= "        outboxTimeline [count] [--no-replies|--replies-only] : Read notes of your follows from their own write relays."
+		strPropagation		= "        propagation <publish-relay> <read-relay> [--wait <sec>] : Measure how fast an event spreads."
+		strPullRelays		= "        pullRelays [--yes] : Merge your published relay list into relays.json."
+		strPurgeRelay		= "        purgeFromRelay <url> [--yes] : Request deletion of your events on one relay."
+		strDoctor			= "        doctor : Check keys, profile, relay list and custom emoji files."
+		strGetProfile		= "        getProfile <npub|nprofile|hex> [--timeout <sec>] : Show the profile of a user."
+		strPullProfile		= "        pullProfile : Replace your profile file with the one published on relays."
+		strFollow			= "        follow <npub> [relay [petname]] [--yes] : Add the user to your follow list."
+		strUnfollow			= "        unfollow <npub> : Remove the user from your follow list."
+		strLsFollows		= "        lsFollows : Show your follow list."
+		strExportFollows	= "        exportFollows <path> [--format csv|ndjson] : Save your follows with their names and NIP-05."
+		strActive			= "        active [days] : List your follows by their last note, marking those silent for days (default 30)."
+		strThread			= "        thread <id> [--depth <n>] : Show the conversation of the note as a tree."
+		strTopic			= "        topic <tag>... [count] [--limit <n>] : Show notes with the hashtags."
+		strCheckMyNip05		= "        checkMyNip05|verifyNip05 : Check that the NIP-05 of your profile points to your key."
+		strNevent			= "        nevent <id> : Make an nevent with relay hints for your note."
+		strFollowDiff		= "        followDiff <npubA> <npubB> : Compare the follow lists of two users."
+		strLastEvent		= "        lastEvent : Show id, tags and content of the event published last."
+		strMentionsOfURL	= "        mentionsOfUrl <url> : Show notes that reference the web page."
+		strSyncReplaceables	= "        syncReplaceables : Copy your profile, follows and relay list to all relays."
+		strRelayHealth		= "        relayHealth : Show how reliable each relay has been."
+		strGetEmojiSet		= "        getEmojiSet <naddr>|<npub> <identifier> [--import] : Show or import an emoji set."
 	)
 
 	fmt.Println(usage)
+	fmt.Println(globalOption)
+	fmt.Println(strSignAs)
+	fmt.Println(strTrace)
+	fmt.Println(strDraft)
+	fmt.Println(strQuietSuccess)
+	fmt.Println(strDumpUnsigned)
+	fmt.Println(strEscapeUnicode)
+	fmt.Println(strLimitContent)
+	fmt.Println(strWireDump)
+	fmt.Println(strOnlyRelay)
+	fmt.Println(strPublishTopic)
+	fmt.Println(strDiscoverRelays)
+	fmt.Println(strVerifyPublish)
+	fmt.Println(strFixClock)
+	fmt.Println(strIndent)
+	fmt.Println(strMaxContent)
+	fmt.Println(strAllowSecret)
+	fmt.Println(strProfile)
+	fmt.Println(strTimeout)
+	fmt.Println(strConcurrency)
 	fmt.Println(subcommand)
 	fmt.Println(strInit)
 	fmt.Println(genkey)
+	fmt.Println(strImportKey)
+	fmt.Println(strEncryptKey)
 	fmt.Println(strListRelay)
 	fmt.Println(strEditRelay)
+	fmt.Println(strAppendRelay)
+	fmt.Println(strAddRelay)
+	fmt.Println(strRemoveRelay)
 	fmt.Println(strPubRelay)
 	fmt.Println(strEditProfile)
 	fmt.Println(strCustomEmoji)
 	fmt.Println(strPublishProfile)
 	fmt.Println(strPublishMessage)
+	fmt.Println(strPublishMessageTo)
+	fmt.Println(strPublishReply)
+	fmt.Println(strThreadPost)
+	fmt.Println(strArchive)
+	fmt.Println(strDelEvent)
+	fmt.Println(strDeleteEvent)
+	fmt.Println(strReaction)
+	fmt.Println(strRepost)
+	fmt.Println(strLsMyReactions)
+	fmt.Println(strUnreact)
+	fmt.Println(strReactions)
+	fmt.Println(strZapTotal)
+	fmt.Println(strStats)
+	fmt.Println(strSelfTest)
+	fmt.Println(strFlush)
+	fmt.Println(strGetEmojiSet)
+	fmt.Println(strRelayHealth)
+	fmt.Println(strConvert)
+	fmt.Println(strEncode)
+	fmt.Println(strDecode)
+	fmt.Println(strGetAddr)
+	fmt.Println(strDefineBadge)
+	fmt.Println(strAwardBadge)
+	fmt.Println(strDraftCmd)
+	fmt.Println(strTimeline)
+	fmt.Println(strOutboxTimeline)
+	fmt.Println(strPropagation)
+	fmt.Println(strPullRelays)
+	fmt.Println(strPurgeRelay)
+	fmt.Println(strSyncReplaceables)
+	fmt.Println(strMentionsOfURL)
+	fmt.Println(strLastEvent)
+	fmt.Println(strFollowDiff)
+	fmt.Println(strNevent)
+	fmt.Println(strCheckMyNip05)
+	fmt.Println(strTopic)
+	fmt.Println(strThread)
+	fmt.Println(strFollow)
+	fmt.Println(strUnfollow)
+	fmt.Println(strLsFollows)
+	fmt.Println(strExportFollows)
+	fmt.Println(strActive)
+	fmt.Println(strGetProfile)
+	fmt.Println(strPullProfile)
+	fmt.Println(strDoctor)
 }
 
 // }}}
@@ -169,7 +703,8 @@ func initEnv() error {
 /*
 Generated Key Pair {{{
 */
-func genKey() error {
+func genKey(args []string) error {
+	encrypt := hasOption(&args, "--encrypt")
 	dirName, err := getDir()
 	if err != nil {
 		return err
@@ -182,18 +717,57 @@ func genKey() error {
 	if err != nil {
 		return err
 	}
-	if err = saveHSecKey(dirName, sk); err != nil {
+	if encrypt {
+		if sk, err = encryptSecretKey(sk); err != nil {
+			return err
+		}
+		nsec = ""
+	}
+	return saveKeyFiles(dirName, sk, pk, nsec, npub)
+}
+
+func importKey(args []string) error {
+	force := hasOption(&args, "--force")
+	encrypt := hasOption(&args, "--encrypt")
+	if len(args) < 1 {
+		fmt.Println("Not set private key.")
+		return errors.New("Not set private key")
+	}
+	sk := strings.ToLower(strings.TrimSpace(args[0]))
+	if !is64HexString(sk) {
+		prefix, v, err := nip19.Decode(sk)
+		if err != nil || prefix != "nsec" {
+			return errors.New("Not an nsec or hex private key")
+		}
+		sk = v.(string)
+	}
+	pk, err := nostr.GetPublicKey(sk)
+	if err != nil {
 		return err
 	}
-	if err = saveHPubKey(dirName, pk); err != nil {
+	nsec, npub, err := genNKey(sk, pk)
+	if err != nil {
 		return err
 	}
-	if err = saveNSecKey(dirName, nsec); err != nil {
+
+	dirName, err := getDir()
+	if err != nil {
 		return err
 	}
-	if err = saveNPubKey(dirName, npub); err != nil {
+	if _, err := os.Stat(dirName + "/" + hsec); err == nil && !force {
+		fmt.Println("A private key already exists. Use --force to replace it.")
+		return errors.New("Private key already exists")
+	}
+	if encrypt {
+		if sk, err = encryptSecretKey(sk); err != nil {
+			return err
+		}
+		nsec = ""
+	}
+	if err := saveKeyFiles(dirName, sk, pk, nsec, npub); err != nil {
 		return err
 	}
+	fmt.Println("imported " + npub)
 	return nil
 }
 
@@ -218,47 +792,175 @@ func genNKey(sk string, pk string) (string, string, error) {
 	return nsec, npub, nil
 }
 
-func saveHSecKey(dn string, sk string) error {
-	path := dn + "/" + hsec
-	fp, err := os.Create(path)
-	if err != nil {
-		return err
+// saveKeyFiles writes the four key files to temporary files first and
+// renames them only when all were written, so a failure never leaves a
+// half-written identity behind. An empty nkey means the secret key is
+// encrypted, and no plaintext .nsec is kept.
+func saveKeyFiles(dn string, sk string, pk string, nkey string, npkey string) (err error) {
+	files := []struct {
+		name string
+		data string
+	}{{hsec, sk}, {hpub, pk}, {npub, npkey}}
+	if nkey != "" {
+		files = append(files, struct {
+			name string
+			data string
+		}{nsec, nkey})
+	}
+
+	var tmps []string
+	defer func() {
+		if err != nil {
+			for _, t := range tmps {
+				os.Remove(t)
+			}
+		}
+	}()
+	for _, f := range files {
+		fp, err := os.CreateTemp(dn, f.name+".tmp")
+		if err != nil {
+			return err
+		}
+		tmps = append(tmps, fp.Name())
+		_, err = fp.WriteString(f.data)
+		if cerr := fp.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for i, f := range files {
+		if err := os.Rename(tmps[i], dn+"/"+f.name); err != nil {
+			return err
+		}
+	}
+	if nkey == "" {
+		if err := os.Remove(dn + "/" + nsec); err != nil && !os.IsNotExist(err) {
+			return err
+		}
 	}
-	defer fp.Close()
-	fp.WriteString(sk)
 	return nil
 }
 
-func saveNSecKey(dn string, nkey string) error {
-	path := dn + "/" + nsec
-	fp, err := os.Create(path)
+func encryptKey() error {
+	dirName, err := getDir()
 	if err != nil {
 		return err
 	}
-	defer fp.Close()
-	fp.WriteString(nkey)
+	b, err := os.ReadFile(dirName + "/" + hsec)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(strings.TrimSpace(string(b)), "ncryptsec1") {
+		fmt.Println("The private key is already encrypted.")
+		return errors.New("Private key is already encrypted")
+	}
+	sk, err := readPrivateKey()
+	if err != nil {
+		return err
+	}
+	pk, err := nostr.GetPublicKey(sk)
+	if err != nil {
+		return err
+	}
+	npub, err := nip19.EncodePublicKey(pk)
+	if err != nil {
+		return err
+	}
+	enc, err := encryptSecretKey(sk)
+	if err != nil {
+		return err
+	}
+	if err := saveKeyFiles(dirName, enc, pk, "", npub); err != nil {
+		return err
+	}
+	fmt.Println("encrypted " + hsec + " and removed " + nsec)
 	return nil
 }
 
-func saveHPubKey(dn string, pk string) error {
-	path := dn + "/" + hpub
-	fp, err := os.Create(path)
+// encryptSecretKey asks for a new passphrase twice and returns sk as a
+// NIP-49 ncryptsec.
+func encryptSecretKey(sk string) (string, error) {
+	pass, err := readPassphrase("New passphrase: ")
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer fp.Close()
-	fp.WriteString(pk)
-	return nil
+	if os.Getenv("NOSTK_PASSPHRASE") == "" {
+		again, err := readPassphrase("Repeat passphrase: ")
+		if err != nil {
+			return "", err
+		}
+		if again != pass {
+			return "", errors.New("Passphrases do not match")
+		}
+	}
+	if pass == "" {
+		return "", errors.New("Empty passphrase")
+	}
+	return encryptNcryptsec(sk, pass, 16)
 }
 
-func saveNPubKey(dn string, nkey string) error {
-	path := dn + "/" + npub
-	fp, err := os.Create(path)
+// }}}
+
+/*
+selfTest {{{
+*/
+func selfTest() error {
+	failed := false
+	result := func(name string, err error) bool {
+		if err != nil {
+			fmt.Printf("FAIL %s: %v\n", name, err)
+			failed = true
+			return false
+		}
+		fmt.Printf("PASS %s\n", name)
+		return true
+	}
+
+	sk, err := readPrivateKey()
+	if !result("read private key", err) {
+		return errors.New("selftest failed")
+	}
+	pk, err := nostr.GetPublicKey(sk)
+	if !result("derive public key", err) {
+		return errors.New("selftest failed")
+	}
+
+	d, err := getDir()
 	if err != nil {
 		return err
 	}
-	defer fp.Close()
-	fp.WriteString(nkey)
+	b, err := os.ReadFile(d + "/" + hpub)
+	if err == nil && strings.TrimSpace(string(b)) != pk {
+		err = errors.New(hpub + " does not match the private key")
+	}
+	result("public key matches "+hpub, err)
+
+	ev := nostr.Event{
+		PubKey:    pk,
+		CreatedAt: eventNow(),
+		Kind:      nostr.KindTextNote,
+		Tags:      nostr.Tags{},
+		Content:   "nostk selftest",
+	}
+	err = ev.Sign(sk)
+	if result("sign event", err) {
+		ok, err := ev.CheckSignature()
+		if err == nil && !ok {
+			err = errors.New("invalid signature")
+		}
+		result("verify signature", err)
+		err = nil
+		if ev.GetID() != ev.ID {
+			err = errors.New("id mismatch")
+		}
+		result("recompute event id", err)
+	}
+
+	if failed {
+		return errors.New("selftest failed")
+	}
 	return nil
 }
 
@@ -267,18 +969,40 @@ func saveNPubKey(dn string, nkey string) error {
 /*
 Listing Relays {{{
 */
-func listRelays() error {
+func listRelays(args []string) error {
 	p := make(map[string]RwFlag)
-	b, err := readRelayList()
+	err := getRelayMap(p)
 	if err != nil {
 		return err
 	}
-	err = json.Unmarshal([]byte(b), &p)
-	if err != nil {
-		return err
+	if containsString(args, "--json") {
+		return printJSON(p)
 	}
-	for i := range p {
-		fmt.Printf("%v R:%v W:%v\n", i, p[i].Read, p[i].Write)
+	groups := []struct {
+		title string
+		read  bool
+		write bool
+	}{
+		{"Read & Write", true, true},
+		{"Read only", true, false},
+		{"Write only", false, true},
+		{"Disabled", false, false},
+	}
+	for _, g := range groups {
+		var l []string
+		for i := range p {
+			if p[i].Read == g.read && p[i].Write == g.write {
+				l = append(l, i)
+			}
+		}
+		if len(l) == 0 {
+			continue
+		}
+		sort.Strings(l)
+		fmt.Printf("%s:\n", g.title)
+		for _, url := range l {
+			fmt.Printf("  %v\n", url)
+		}
 	}
 	return nil
 }
@@ -316,51 +1040,121 @@ func editRelayList() error {
 // }}}
 
 /*
-editCustomEmojiList {{{
+appendRelayList {{{
 */
-func editCustomEmojiList() error {
-	e := os.Getenv("EDITOR")
-	if e == "" {
-		return errors.New("Not set EDITOR environmental variables")
+func appendRelayList(s string) error {
+	if err := checkRelayListWritable(); err != nil {
+		return err
 	}
-	d, err := getDir()
+	p := make(map[string]RwFlag)
+	err := getRelayMap(p)
 	if err != nil {
+		fmt.Println("Not found relay list. Use \"nostk init\"")
 		return err
 	}
-	path := d + "/" + emoji
-	if _, err := os.Stat(path); err != nil {
-		fmt.Println("Not found custom emoji list. Use \"nostk init\"")
-		return errors.New("Not found custom emoji list")
+	// check every URL first, so a typo does not leave the list half added
+	var urls []string
+	for _, url := range strings.Split(s, ",") {
+		url = strings.TrimSpace(url)
+		if url == "" {
+			continue
+		}
+		if !isRelayURL(url) {
+			return errors.New("Invalid relay URL: " + url)
+		}
+		urls = append(urls, normalizeRelayURL(url))
 	}
-	c := exec.Command(e, path)
-	c.Stdin = os.Stdin
-	c.Stdout = os.Stdout
-	c.Stderr = os.Stderr
-	if err := c.Run(); err != nil {
-		return err
+	for _, url := range urls {
+		if _, ok := p[url]; ok {
+			fmt.Printf("%s is already in relay list\n", url)
+			continue
+		}
+		p[url] = RwFlag{true, true, nil}
+		fmt.Printf("added %s\n", url)
 	}
-
-	return nil
+	return writeRelayList(p)
 }
 
 // }}}
 
 /*
-editProfile {{{
+addRelay {{{
 */
-func editProfile() error {
-	e := os.Getenv("EDITOR")
-	if e == "" {
-		return errors.New("Not set EDITOR environmental variables")
+func addRelay(args []string) error {
+	read := hasOption(&args, "--read")
+	write := hasOption(&args, "--write")
+	if !read && !write {
+		read, write = true, true
 	}
-	d, err := getDir()
-	if err != nil {
-		return err
+	if len(args) < 1 {
+		return errors.New("Not set relay URL")
 	}
-	path := d + "/" + profile
-	if _, err := os.Stat(path); err != nil {
-		fmt.Println("Not found profile file.")
-		return errors.New("Not found profile file")
+	if !isRelayURL(args[0]) {
+		return errors.New("Invalid relay URL: " + args[0])
+	}
+	if err := checkRelayListWritable(); err != nil {
+		return err
+	}
+	p := make(map[string]RwFlag)
+	if err := getRelayMap(p); err != nil {
+		fmt.Println("Not found relay list. Use \"nostk init\"")
+		return err
+	}
+	url := normalizeRelayURL(args[0])
+	if f, ok := p[url]; ok {
+		fmt.Printf("updated %s R:%v W:%v\n", url, read, write)
+		p[url] = RwFlag{read, write, f.Topics}
+	} else {
+		fmt.Printf("added %s R:%v W:%v\n", url, read, write)
+		p[url] = RwFlag{read, write, nil}
+	}
+	return writeRelayList(p)
+}
+
+// }}}
+
+/*
+removeRelay {{{
+*/
+func removeRelay(args []string) error {
+	if len(args) < 1 {
+		return errors.New("Not set relay URL")
+	}
+	if err := checkRelayListWritable(); err != nil {
+		return err
+	}
+	p := make(map[string]RwFlag)
+	if err := getRelayMap(p); err != nil {
+		fmt.Println("Not found relay list. Use \"nostk init\"")
+		return err
+	}
+	url := normalizeRelayURL(args[0])
+	if _, ok := p[url]; !ok {
+		return errors.New("Not in relay list: " + url)
+	}
+	delete(p, url)
+	fmt.Printf("removed %s\n", url)
+	return writeRelayList(p)
+}
+
+// }}}
+
+/*
+editCustomEmojiList {{{
+*/
+func editCustomEmojiList() error {
+	e := os.Getenv("EDITOR")
+	if e == "" {
+		return errors.New("Not set EDITOR environmental variables")
+	}
+	d, err := getDir()
+	if err != nil {
+		return err
+	}
+	path := d + "/" + emoji
+	if _, err := os.Stat(path); err != nil {
+		fmt.Println("Not found custom emoji list. Use \"nostk init\"")
+		return errors.New("Not found custom emoji list")
 	}
 	c := exec.Command(e, path)
 	c.Stdin = os.Stdin
@@ -376,58 +1170,108 @@ func editProfile() error {
 // }}}
 
 /*
-publishProfile {{{
+editProfile {{{
 */
-func publishProfile() error {
-	var rl []string
-	s, err := readProfile()
+func editProfile() error {
+	e := os.Getenv("EDITOR")
+	if e == "" {
+		return errors.New("Not set EDITOR environmental variables")
+	}
+	d, err := getDir()
 	if err != nil {
-		fmt.Println("Not found your profile. Use \"nostk init\" and \"nostk editProfile\".")
 		return err
 	}
-	sk, err := readPrivateKey()
-	if err != nil {
-		fmt.Println("Nothing key pair. Make key pair.")
+	path := d + "/" + profile
+	if _, err := os.Stat(path); err != nil {
+		fmt.Println("Not found profile file.")
+		return errors.New("Not found profile file")
+	}
+	c := exec.Command(e, path)
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
 		return err
 	}
-	pk, err := nostr.GetPublicKey(sk)
+
+	return nil
+}
+
+// }}}
+
+/*
+publishProfile {{{
+*/
+func publishProfile(args []string) error {
+	var rl []string
+	var s string
+	checkNip05 := hasOption(&args, "--verify-nip05")
+	if path, ok := getOption(&args, "--file"); ok {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		s = strings.ReplaceAll(string(b), "\n", "")
+		var p ProfileMetadata
+		if err := json.Unmarshal([]byte(s), &p); err != nil {
+			fmt.Printf("%s is not a profile JSON.\n", path)
+			return err
+		}
+	} else if containsString(args, "--file") {
+		return errors.New("Not set profile file")
+	} else {
+		var err error
+		s, err = readProfile()
+		if err != nil {
+			fmt.Println("Not found your profile. Use \"nostk init\" and \"nostk editProfile\".")
+			return err
+		}
+	}
+	sk, pk, err := readKeyPair()
 	if err != nil {
 		return err
 	}
+	if checkNip05 {
+		// a stale NIP-05 is worth a warning, not a refusal to publish
+		var p ProfileMetadata
+		if err := json.Unmarshal([]byte(s), &p); err != nil {
+			fmt.Println("warning: cannot read the profile to check nip05:", err)
+		} else if p.NIP05 == "" {
+			fmt.Println("warning: nip05 is not set in the profile")
+		} else if err := verifyNip05(p.NIP05, pk); err != nil {
+			fmt.Println("warning:", err)
+		}
+	}
 
-	if err := getRelayList(&rl); err != nil {
+	if err := getWriteRelays(&rl); err != nil {
 		fmt.Println("Nothing relay list. Make a relay list.")
 		return err
 	}
 
+	ca := nextReplaceableTime(pk, nostr.KindSetMetadata, rl)
+	// the relays asked may not include the one pullProfile read from
+	if pulled := readProfileTime(); ca <= pulled {
+		ca = pulled + 1
+	}
+	ev := profileEvent(pk, s, ca)
+	if err := signEvent(&ev, sk); err != nil {
+		return err
+	}
+
+	return publishEvent(ev, rl)
+}
+
+// profileEvent builds the unsigned kind 0 from the profile JSON, turning the
+// escaped newlines of about and the like back into real ones.
+func profileEvent(pk string, s string, ca nostr.Timestamp) nostr.Event {
 	pr := strings.Replace(s,"\\n","\n",-1)
-	ev := nostr.Event{
+	return nostr.Event{
 		PubKey:    pk,
-		CreatedAt: nostr.Now(),
+		CreatedAt: ca,
 		Kind:      nostr.KindSetMetadata,
 		Tags:      nil,
 		Content:   string(pr),
 	}
-
-	// calling Sign sets the event ID field and the event Sig field
-	ev.Sign(sk)
-
-	// publish the event to two relays
-	ctx := context.Background()
-	for _, url := range rl {
-		relay, err := nostr.RelayConnect(ctx, url)
-		if err != nil {
-			fmt.Println(err)
-			continue
-		}
-		_, err = relay.Publish(ctx, ev)
-		if err != nil {
-			fmt.Println(err)
-			continue
-		}
-		fmt.Printf("published to %s\n", url)
-	}
-	return nil
 }
 
 // }}}
@@ -435,253 +1279,4996 @@ func publishProfile() error {
 /*
 publishMessage {{{
 */
-func publishMessage(s string) error {
+func publishMessage(s string, etgs nostr.Tags) (string, error) {
 	var rl []string
 
 	if len(s) < 1 {
 		fmt.Println("Nothing text message.")
-		return errors.New("Not set text message")
+		return "", errors.New("Not set text message")
 	}
 
-	sk, err := readPrivateKey()
+	sk, pk, err := readKeyPair()
 	if err != nil {
-		fmt.Println("Nothing key pair. Make key pair.")
-		return err
+		return "", err
 	}
-	pk, err := nostr.GetPublicKey(sk)
+
+	if err := getWriteRelays(&rl); err != nil {
+		fmt.Println("Nothing relay list. Make a relay list.")
+		return "", err
+	}
+
+	ev, err := messageEvent(pk, s, etgs)
 	if err != nil {
-		return err
+		return "", err
+	}
+	if err := signEvent(&ev, sk); err != nil {
+		return "", err
 	}
 
-	if err := getRelayList(&rl); err != nil {
-		fmt.Println("Nothing relay list. Make a relay list.")
-		return err
+	if err := publishEvent(ev, rl); err != nil {
+		return "", err
 	}
+	return ev.ID, nil
+}
 
+// messageEvent builds the unsigned note: custom emoji and hashtags found in
+// s come first, then the tags given by the caller.
+func messageEvent(pk string, s string, etgs nostr.Tags) (nostr.Event, error) {
 	tgs := nostr.Tags{}
 	if err := setCustomEmoji(s, &tgs); err!=nil {
-		return err
+		return nostr.Event{}, err
 	}
+	setHashTags(s, &tgs)
+	tgs = append(tgs, etgs...)
 
-	ev := nostr.Event{
+	return nostr.Event{
 		PubKey:    pk,
-		CreatedAt: nostr.Now(),
+		CreatedAt: eventNow(),
 		Kind:      nostr.KindTextNote,
 		Tags:      tgs,
 		Content:   s,
-	}
+	}, nil
+}
 
-	// calling Sign sets the event ID field and the event Sig field
-	ev.Sign(sk)
+// }}}
 
-	// publish the event to two relays
-	ctx := context.Background()
-	for _, url := range rl {
-		relay, err := nostr.RelayConnect(ctx, url)
+/*
+deleteEvent {{{
+*/
+func deleteEvent(args []string) error {
+	reason, _ := getOption(&args, "--reason")
+	if path, ok := getOption(&args, "--from-file"); ok {
+		b, err := os.ReadFile(path)
 		if err != nil {
-			fmt.Println(err)
-			continue
+			return err
+		}
+		for _, l := range strings.Split(string(b), "\n") {
+			if l = strings.TrimSpace(l); l != "" {
+				args = append(args, l)
+			}
 		}
-		_, err = relay.Publish(ctx, ev)
+	}
+
+	tags := nostr.Tags{}
+	for _, a := range args {
+		id, err := resolveEventID(a)
 		if err != nil {
-			fmt.Println(err)
+			fmt.Printf("skip %s: %v\n", a, err)
 			continue
 		}
-		fmt.Printf("published to %s\n", url)
+		tags = tags.AppendUnique(nostr.Tag{"e", id})
+	}
+	if len(tags) == 0 {
+		fmt.Println("Nothing event id.")
+		return errors.New("Not set valid event id")
+	}
+
+	sk, pk, err := readKeyPair()
+	if err != nil {
+		return err
+	}
+
+	var rl []string
+	if err := getWriteRelays(&rl); err != nil {
+		fmt.Println("Nothing relay list. Make a relay list.")
+		return err
+	}
+
+	ev := nostr.Event{
+		PubKey:    pk,
+		CreatedAt: eventNow(),
+		Kind:      nostr.KindDeletion,
+		Tags:      tags,
+		Content:   reason,
+	}
+
+	if err := signEvent(&ev, sk); err != nil {
+		return err
 	}
 
+	if err := publishEvent(ev, rl); err != nil {
+		return err
+	}
+	fmt.Println("Deletion is a request; relays and clients may keep showing the event.")
 	return nil
 }
 
 // }}}
 
 /*
-	publishRelayList {{{
+publishReaction {{{
 */
-func publishRelayList() error {
-	p := make(map[string]RwFlag)
-	b, err := readRelayList()
+func publishReaction(args []string) error {
+	if len(args) < 1 {
+		fmt.Println("Not set event id.")
+		return errors.New("Not set event id")
+	}
+	id, err := resolveEventID(args[0])
 	if err != nil {
 		return err
 	}
-	err = json.Unmarshal([]byte(b), &p)
+	content := "+"
+	if len(args) > 1 {
+		content = args[1]
+	}
+	tgs := nostr.Tags{}
+	// a custom emoji is sent as its shortcode with an emoji tag (NIP-30)
+	if len(content) > 2 && strings.HasPrefix(content, ":") && strings.HasSuffix(content, ":") {
+		name := content[1 : len(content)-1]
+		local := make(map[string]string)
+		getCustomEmoji(&local)
+		u, ok := local[name]
+		if !ok {
+			fmt.Printf("Not found :%s: in %s.\n", name, emoji)
+			return errors.New("Unknown custom emoji: " + name)
+		}
+		tgs = append(tgs, nostr.Tag{"emoji", name, u})
+	}
+
+	var rl []string
+	if err := getReadRelays(&rl); err != nil {
+		fmt.Println("Nothing relay list. Make a relay list.")
+		return err
+	}
+	evs, err := queryEvents(rl, nostr.Filter{IDs: []string{id}})
 	if err != nil {
 		return err
 	}
-	const (
-		cRead	= "read"
-		cWrite	= "write"
-	)
-	tags := nostr.Tags{}
-	for i := range p {
-		t := nostr.Tag {"r", i}
-	    if p[i].Read == true && p[i].Write == true {
-		}else if p[i].Read == true {
-			t = append(t, cRead)
-		}else if p[i].Write == true {
-			t = append(t, cWrite)
+	if len(evs) == 0 {
+		fmt.Println("Not found the note to react to on any relay.")
+		return errors.New("Not found event: " + id)
+	}
+	target := evs[0]
+
+	sk, pk, err := readKeyPair()
+	if err != nil {
+		return err
+	}
+	rl = nil
+	if err := getWriteRelays(&rl); err != nil {
+		fmt.Println("Nothing relay list. Make a relay list.")
+		return err
+	}
+	ev := nostr.Event{
+		PubKey:    pk,
+		CreatedAt: eventNow(),
+		Kind:      nostr.KindReaction,
+		Tags: append(nostr.Tags{
+			{"e", target.ID},
+			{"p", target.PubKey},
+			{"k", strconv.Itoa(target.Kind)},
+		}, tgs...),
+		Content: content,
+	}
+	if err := signEvent(&ev, sk); err != nil {
+		return err
+	}
+	return publishEvent(ev, rl)
+}
+
+// }}}
+
+/*
+publishRepost {{{
+*/
+// publishRepost boosts a note with a kind 6 event (NIP-18); other kinds get a
+// generic kind 16 repost. The e tag carries the relay the event was found on,
+// and the content holds the original event unless --no-embed is given.
+func publishRepost(args []string) error {
+	noEmbed := hasOption(&args, "--no-embed")
+	if len(args) < 1 {
+		fmt.Println("Not set event id.")
+		return errors.New("Not set event id")
+	}
+	id, err := resolveEventID(args[0])
+	if err != nil {
+		return err
+	}
+
+	var rl []string
+	if err := getReadRelays(&rl); err != nil {
+		fmt.Println("Nothing relay list. Make a relay list.")
+		return err
+	}
+	// relay hints of an nevent are tried before our own relays
+	if prefix, v, err := nip19.Decode(strings.TrimPrefix(args[0], "nostr:")); err == nil && prefix == "nevent" {
+		rl = withRelayHints(v.(nostr.EventPointer).Relays, rl)
+	}
+	var target *nostr.Event
+	var found string
+	for _, u := range rl {
+		evs, _ := queryEvents([]string{u}, nostr.Filter{IDs: []string{id}})
+		if len(evs) > 0 {
+			target, found = evs[0], u
+			break
 		}
-		tags = append(tags,t)
+	}
+	if target == nil {
+		fmt.Println("Not found the note to repost on any relay.")
+		return errors.New("Not found event: " + id)
 	}
 
-	sk, err := readPrivateKey()
+	sk, pk, err := readKeyPair()
 	if err != nil {
-		fmt.Println("Nothing key pair. Make key pair.")
 		return err
 	}
-	pk, err := nostr.GetPublicKey(sk)
+	rl = nil
+	if err := getWriteRelays(&rl); err != nil {
+		fmt.Println("Nothing relay list. Make a relay list.")
+		return err
+	}
+	ev := nostr.Event{
+		PubKey:    pk,
+		CreatedAt: eventNow(),
+		Kind:      nostr.KindRepost,
+		Tags: nostr.Tags{
+			{"e", target.ID, found},
+			{"p", target.PubKey},
+		},
+	}
+	if target.Kind != nostr.KindTextNote {
+		ev.Kind = 16
+		ev.Tags = append(ev.Tags, nostr.Tag{"k", strconv.Itoa(target.Kind)})
+	}
+	if !noEmbed {
+		b, err := json.Marshal(target)
+		if err != nil {
+			return err
+		}
+		ev.Content = string(b)
+	}
+	if err := signEvent(&ev, sk); err != nil {
+		return err
+	}
+	return publishEvent(ev, rl)
+}
+
+// }}}
+
+/*
+listMyReactions {{{
+*/
+func listMyReactions(args []string) error {
+	count := 20
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			return errors.New("Invalid count: " + args[0])
+		}
+		count = n
+	}
+	pk, err := readPublicKey()
+	if err != nil {
+		return err
+	}
+	var rl []string
+	if err := getReadRelays(&rl); err != nil {
+		fmt.Println("Nothing relay list. Make a relay list.")
+		return err
+	}
+
+	rs, err := queryEvents(rl, nostr.Filter{
+		Kinds:   []int{nostr.KindReaction},
+		Authors: []string{pk},
+		Limit:   count,
+	})
 	if err != nil {
 		return err
 	}
+	if len(rs) > count {
+		rs = rs[:count]
+	}
+	if len(rs) == 0 {
+		fmt.Println("Nothing reactions.")
+		return nil
+	}
+	var ids []string
+	for _, r := range rs {
+		if id := reactionTarget(r); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	notes := make(map[string]*nostr.Event)
+	if len(ids) > 0 {
+		evs, _ := queryEvents(rl, nostr.Filter{IDs: ids})
+		for _, ev := range evs {
+			notes[ev.ID] = ev
+		}
+	}
+
+	for _, r := range rs {
+		c := r.Content
+		if c == "" {
+			c = "+"
+		}
+		id := reactionTarget(r)
+		note, _ := nip19.EncodeNote(id)
+		fmt.Printf("%s %s %s\n", r.CreatedAt.Time().Format("2006-01-02 15:04:05"), displayContent(c), note)
+		if ev, ok := notes[id]; ok {
+			for _, l := range strings.Split(displayContent(limitedContent(ev.Content)), "\n") {
+				fmt.Println("  " + l)
+			}
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+// reactionTarget returns the id of the note a reaction is for. NIP-25 puts
+// it in the last "e" tag.
+func reactionTarget(r *nostr.Event) string {
+	es := r.Tags.GetAll([]string{"e", ""})
+	if len(es) == 0 || !is64HexString(es[len(es)-1].Value()) {
+		return ""
+	}
+	return es[len(es)-1].Value()
+}
+
+// }}}
+
+/*
+unreact {{{
+*/
+func unreact(args []string) error {
+	if len(args) < 1 {
+		fmt.Println("Not set event id.")
+		return errors.New("Not set event id")
+	}
+	id, err := resolveEventID(args[0])
+	if err != nil {
+		return err
+	}
+	pk, err := readPublicKey()
+	if err != nil {
+		return err
+	}
+	var rl []string
+	if err := getReadRelays(&rl); err != nil {
+		fmt.Println("Nothing relay list. Make a relay list.")
+		return err
+	}
+
+	rs, err := queryEvents(rl, nostr.Filter{
+		Kinds:   []int{nostr.KindReaction},
+		Authors: []string{pk},
+		Tags:    nostr.TagMap{"e": []string{id}},
+	})
+	if err != nil {
+		return err
+	}
+	// the note may only be mentioned in an earlier "e" tag of the reaction
+	var ids []string
+	for _, r := range rs {
+		if reactionTarget(r) == id {
+			ids = append(ids, r.ID)
+		}
+	}
+	if len(ids) == 0 {
+		fmt.Println("Nothing your reaction to the note.")
+		return errors.New("No reaction found")
+	}
+	return deleteEvent(ids)
+}
+
+// }}}
+
+/*
+delEvent {{{
+*/
+// delEvent is deleteEvent for a single event, with the reason given as the
+// rest of the arguments.
+func delEvent(args []string) error {
+	if len(args) < 1 {
+		fmt.Println("Nothing event id.")
+		return errors.New("Not set event id")
+	}
+	return deleteEvent([]string{args[0], "--reason", strings.Join(args[1:], " ")})
+}
+
+// }}}
+
+/*
+defineBadge {{{
+*/
+func defineBadge(args []string) error {
+	if len(args) < 3 {
+		fmt.Println("Usage: nostk defineBadge <identifier> <name> <image-url>")
+		return errors.New("Not enough badge parameters")
+	}
+	if !strings.HasPrefix(args[2], "https://") && !strings.HasPrefix(args[2], "http://") {
+		return errors.New("Invalid image URL: " + args[2])
+	}
+
+	sk, pk, err := readKeyPair()
+	if err != nil {
+		return err
+	}
+
+	var rl []string
+	if err := getWriteRelays(&rl); err != nil {
+		fmt.Println("Nothing relay list. Make a relay list.")
+		return err
+	}
+
+	ev := nostr.Event{
+		PubKey:    pk,
+		CreatedAt: eventNow(),
+		Kind:      nostr.KindBadgeDefinition,
+		Tags: nostr.Tags{
+			{"d", args[0]},
+			{"name", args[1]},
+			{"image", args[2]},
+		},
+		Content: "",
+	}
+
+	if err := signEvent(&ev, sk); err != nil {
+		return err
+	}
+	if err := publishEvent(ev, rl); err != nil {
+		return err
+	}
+	naddr, err := nip19.EncodeEntity(pk, ev.Kind, args[0], nil)
+	if err != nil {
+		return err
+	}
+	fmt.Println(naddr)
+	return nil
+}
+
+// }}}
+
+/*
+awardBadge {{{
+*/
+const kindBadgeAward = 8
+
+func awardBadge(args []string) error {
+	if len(args) < 2 {
+		fmt.Println("Usage: nostk awardBadge <badge-naddr> <npub>...")
+		return errors.New("Not enough badge parameters")
+	}
+	prefix, v, err := nip19.Decode(args[0])
+	if err != nil {
+		return err
+	}
+	ep, ok := v.(nostr.EntityPointer)
+	if prefix != "naddr" || !ok || ep.Kind != nostr.KindBadgeDefinition {
+		return errors.New("Not a badge definition naddr: " + args[0])
+	}
+
+	tags := nostr.Tags{
+		{"a", fmt.Sprintf("%d:%s:%s", ep.Kind, ep.PublicKey, ep.Identifier)},
+	}
+	for _, a := range args[1:] {
+		pk, err := decodePubKey(a)
+		if err != nil {
+			return fmt.Errorf("%s: %w", a, err)
+		}
+		tags = tags.AppendUnique(nostr.Tag{"p", pk})
+	}
+
+	sk, pk, err := readKeyPair()
+	if err != nil {
+		return err
+	}
+
+	var rl []string
+	if err := getWriteRelays(&rl); err != nil {
+		fmt.Println("Nothing relay list. Make a relay list.")
+		return err
+	}
+
+	ev := nostr.Event{
+		PubKey:    pk,
+		CreatedAt: eventNow(),
+		Kind:      kindBadgeAward,
+		Tags:      tags,
+		Content:   "",
+	}
+
+	if err := signEvent(&ev, sk); err != nil {
+		return err
+	}
+	return publishEvent(ev, rl)
+}
+
+// }}}
+
+/*
+draftCommand {{{
+*/
+func draftCommand(args []string) error {
+	if len(args) < 1 {
+		fmt.Println("Usage: nostk draft save|list|show|publish|rm [name]")
+		return errors.New("Not set draft sub-command")
+	}
+	d, err := getDir()
+	if err != nil {
+		return err
+	}
+	dir := d + "/" + drafts
+
+	if args[0] == "list" {
+		fs, err := os.ReadDir(dir)
+		if err != nil || len(fs) == 0 {
+			fmt.Println("Nothing drafts.")
+			return nil
+		}
+		for _, f := range fs {
+			name := strings.TrimSuffix(f.Name(), ".json")
+			dr, err := readDraft(dir, name)
+			if err != nil {
+				fmt.Printf("%s: %v\n", name, err)
+				continue
+			}
+			line := strings.SplitN(strings.TrimSpace(dr.Content), "\n", 2)[0]
+			fmt.Printf("%s\t%s\t%s\n", name, time.Unix(dr.SavedAt, 0).Format("2006-01-02 15:04"), line)
+		}
+		return nil
+	}
+
+	if len(args) < 2 {
+		fmt.Println("Nothing draft name.")
+		return errors.New("Not set draft name")
+	}
+	name := args[1]
+	if name == "" || strings.ContainsAny(name, "/\\") || strings.HasPrefix(name, ".") {
+		return errors.New("Invalid draft name: " + name)
+	}
+	path := dir + "/" + name + ".json"
+
+	switch args[0] {
+	case "save":
+		rest := args[2:]
+		dr := Draft{Tags: nostr.Tags{}, SavedAt: time.Now().Unix()}
+		if err := setMessageOptions(&rest, &dr.Tags); err != nil {
+			return err
+		}
+		if dr.Content, err = readMessage(&rest); err != nil {
+			return err
+		}
+		b, err := json.MarshalIndent(dr, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, b, 0600); err != nil {
+			return err
+		}
+		fmt.Printf("saved draft %s\n", name)
+	case "show":
+		dr, err := readDraft(dir, name)
+		if err != nil {
+			return err
+		}
+		for _, t := range dr.Tags {
+			fmt.Printf("tag: %s\n", strings.Join(t, " "))
+		}
+		fmt.Println(displayContent(dr.Content))
+	case "publish":
+		dr, err := readDraft(dir, name)
+		if err != nil {
+			return err
+		}
+		if _, err := publishMessage(dr.Content, dr.Tags); err != nil {
+			return err
+		}
+		return os.Remove(path)
+	case "rm":
+		if err := os.Remove(path); err != nil {
+			fmt.Println("Not found draft: " + name)
+			return err
+		}
+	default:
+		return errors.New("Unknown draft sub-command: " + args[0])
+	}
+	return nil
+}
+
+func readDraft(dir string, name string) (Draft, error) {
+	var dr Draft
+	b, err := os.ReadFile(dir + "/" + name + ".json")
+	if err != nil {
+		fmt.Println("Not found draft: " + name)
+		return dr, err
+	}
+	err = json.Unmarshal(b, &dr)
+	return dr, err
+}
+
+// }}}
+
+/*
+	publishRelayList {{{
+*/
+func publishRelayList() error {
+	p := make(map[string]RwFlag)
+	err := getRelayMap(p)
+	if err != nil {
+		return err
+	}
+	tags := relayListTags(p)
+	if len(tags) == 0 {
+		fmt.Println("Nothing valid relay in relay list. Use \"nostk addRelay\".")
+		return errors.New("No relay to publish")
+	}
+
+	sk, pk, err := readKeyPair()
+	if err != nil {
+		return err
+	}
+
+	var rl []string
+	if err := getRelayList(&rl); err != nil {
+		fmt.Println("Nothing relay list. Make a relay list.")
+		return err
+	}
+	var valid []string
+	for _, u := range rl {
+		if isRelayURL(u) {
+			valid = append(valid, u)
+		}
+	}
+	rl = valid
+
+	ev := relayListEvent(pk, tags, nextReplaceableTime(pk, nostr.KindRelayListMetadata, rl))
+	if err := signEvent(&ev, sk); err != nil {
+		return err
+	}
+
+	return publishEvent(ev, rl)
+}
+
+// relayListEvent builds the unsigned NIP-65 relay list from the r tags.
+func relayListEvent(pk string, tags nostr.Tags, ca nostr.Timestamp) nostr.Event {
+	return nostr.Event{
+		PubKey:    pk,
+		CreatedAt: ca,
+		Kind:      nostr.KindRelayListMetadata,
+		Tags:      tags,
+		Content:   "",
+	}
+}
+
+// relayListTags makes the r tags of NIP-65 from the relay list, sorted by
+// URL and skipping entries that are not relay URLs.
+func relayListTags(p map[string]RwFlag) nostr.Tags {
+	const (
+		cRead	= "read"
+		cWrite	= "write"
+	)
+	keys := make([]string, 0, len(p))
+	for i := range p {
+		// init seeds relays.json with an empty URL to be filled in
+		if i == "" {
+			continue
+		}
+		if !isRelayURL(i) {
+			fmt.Printf("skip %q: not a ws:// or wss:// URL\n", i)
+			continue
+		}
+		keys = append(keys, i)
+	}
+	sort.Strings(keys)
+	tags := nostr.Tags{}
+	for _, i := range keys {
+		t := nostr.Tag {"r", i}
+	    if p[i].Read == true && p[i].Write == true {
+		}else if p[i].Read == true {
+			t = append(t, cRead)
+		}else if p[i].Write == true {
+			t = append(t, cWrite)
+		}else {
+			// an r tag without a marker would mean read and write
+			continue
+		}
+		tags = append(tags,t)
+	}
+	return tags
+}
+// }}}
+
+/*
+archiveNotes {{{
+*/
+func archiveNotes(args []string) error {
+	format, ok := getOption(&args, "--format")
+	if !ok {
+		format = "md"
+	}
+	if format != "md" && format != "html" {
+		return errors.New("Unknown archive format: " + format)
+	}
+	longForm := hasOption(&args, "--long-form")
+	if len(args) < 1 {
+		fmt.Println("Nothing archive directory.")
+		return errors.New("Not set archive directory")
+	}
+	dir := args[0]
+
+	pk, err := readPublicKey()
+	if err != nil {
+		return err
+	}
+
+	var rl []string
+	if err := getReadRelays(&rl); err != nil {
+		fmt.Println("Nothing relay list. Make a relay list.")
+		return err
+	}
+
+	kinds := []int{nostr.KindTextNote}
+	if longForm {
+		kinds = append(kinds, nostr.KindArticle)
+	}
+
+	evs, err := queryAllEvents(rl, nostr.Filter{Kinds: kinds, Authors: []string{pk}})
+	if err != nil {
+		return err
+	}
+	sort.Slice(evs, func(i, j int) bool {
+		return evs[i].CreatedAt < evs[j].CreatedAt
+	})
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, ev := range evs {
+		name := ev.CreatedAt.Time().Format("2006-01-02-150405") + "-" + ev.ID[:8] + "." + format
+		var b string
+		if format == "html" {
+			b = archiveHTML(ev)
+		} else {
+			b = archiveMarkdown(ev)
+		}
+		if err := os.WriteFile(dir+"/"+name, []byte(b), 0644); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("archived %d notes to %s\n", len(evs), dir)
+	return nil
+}
+
+var nostrURIRegexp = regexp.MustCompile(`nostr:((?:npub|note|nevent|nprofile|naddr)1[02-9ac-hj-np-z]+)`)
+
+func archiveMarkdown(ev *nostr.Event) string {
+	var sb strings.Builder
+	sb.WriteString("---\n")
+	sb.WriteString("id: " + ev.ID + "\n")
+	sb.WriteString(fmt.Sprintf("kind: %d\n", ev.Kind))
+	sb.WriteString("created_at: " + ev.CreatedAt.Time().Format(time.RFC3339) + "\n")
+	if t := ev.Tags.GetFirst([]string{"title", ""}); t != nil {
+		sb.WriteString(fmt.Sprintf("title: %q\n", t.Value()))
+	}
+	sb.WriteString("---\n\n")
+	sb.WriteString(nostrURIRegexp.ReplaceAllString(ev.Content, "[nostr:$1](https://njump.me/$1)"))
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+func archiveHTML(ev *nostr.Event) string {
+	ts := ev.CreatedAt.Time().Format(time.RFC3339)
+	title := ts
+	if t := ev.Tags.GetFirst([]string{"title", ""}); t != nil {
+		title = t.Value()
+	}
+	c := html.EscapeString(ev.Content)
+	c = nostrURIRegexp.ReplaceAllString(c, `<a href="https://njump.me/$1">nostr:$1</a>`)
+	c = strings.ReplaceAll(c, "\n", "<br>\n")
+
+	var sb strings.Builder
+	sb.WriteString("<!DOCTYPE html>\n<html>\n<head>\n")
+	sb.WriteString("<meta charset=\"utf-8\">\n")
+	sb.WriteString("<meta name=\"nostr:id\" content=\"" + ev.ID + "\">\n")
+	sb.WriteString("<meta name=\"nostr:created_at\" content=\"" + ts + "\">\n")
+	sb.WriteString("<title>" + html.EscapeString(title) + "</title>\n")
+	sb.WriteString("</head>\n<body>\n<article>\n<p>\n")
+	sb.WriteString(c)
+	sb.WriteString("\n</p>\n<footer>" + ts + " " + ev.ID + "</footer>\n")
+	sb.WriteString("</article>\n</body>\n</html>\n")
+	return sb.String()
+}
+
+// }}}
+
+/*
+reactionSummary {{{
+*/
+func reactionSummary(args []string) error {
+	emojiOnly := hasOption(&args, "--emoji-only")
+	limit := 50
+	if l, ok := getOption(&args, "--limit"); ok {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 {
+			return errors.New("Invalid limit: " + l)
+		}
+		limit = n
+	}
+
+	var pk string
+	var err error
+	if len(args) > 0 {
+		pk, err = decodePubKey(args[0])
+	} else {
+		pk, err = readPublicKey()
+	}
+	if err != nil {
+		return err
+	}
+
+	var rl []string
+	if err := getReadRelays(&rl); err != nil {
+		fmt.Println("Nothing relay list. Make a relay list.")
+		return err
+	}
+
+	notes, err := queryEvents(rl, nostr.Filter{
+		Kinds:   []int{nostr.KindTextNote},
+		Authors: []string{pk},
+		Limit:   limit,
+	})
+	if err != nil {
+		return err
+	}
+	if len(notes) == 0 {
+		fmt.Println("Nothing notes.")
+		return nil
+	}
+	var ids []string
+	for _, ev := range notes {
+		ids = append(ids, ev.ID)
+	}
+	rs, err := queryEvents(rl, nostr.Filter{
+		Kinds: []int{nostr.KindReaction},
+		Tags:  nostr.TagMap{"e": ids},
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(rs) == 0 {
+		fmt.Println("Nothing reactions.")
+		return nil
+	}
+	counts := make(map[string]int)
+	urls := make(map[string]string)
+	for _, r := range rs {
+		if !emojiOnly {
+			c := r.Content
+			if c == "" {
+				c = "+"
+			}
+			counts[c]++
+			continue
+		}
+		for _, t := range r.Tags.GetAll([]string{"emoji", ""}) {
+			if len(t) < 3 || r.Content != ":"+t[1]+":" {
+				continue
+			}
+			counts[t[1]]++
+			urls[t[1]] = t[2]
+		}
+	}
+
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+
+	if !emojiOnly {
+		for _, k := range keys {
+			fmt.Printf("%5d %s\n", counts[k], displayContent(k))
+		}
+		return nil
+	}
+
+	// mark the emoji that are also registered in customemoji.json
+	local := make(map[string]string)
+	getCustomEmoji(&local)
+	for _, k := range keys {
+		mark := ""
+		if u, ok := local[k]; ok && u == urls[k] {
+			mark = " (local)"
+		}
+		fmt.Printf("%5d :%s: %s%s\n", counts[k], k, urls[k], mark)
+	}
+	return nil
+}
+
+// }}}
+
+/*
+zapTotal {{{
+*/
+func zapTotal(args []string) error {
+	if len(args) < 1 {
+		fmt.Println("Not set event id.")
+		return errors.New("Not set event id")
+	}
+	id, err := resolveEventID(args[0])
+	if err != nil {
+		return err
+	}
+	var rl []string
+	if err := getReadRelays(&rl); err != nil {
+		fmt.Println("Nothing relay list. Make a relay list.")
+		return err
+	}
+
+	// queryEvents already drops receipts seen on more than one relay
+	rs, err := queryEvents(rl, nostr.Filter{
+		Kinds: []int{9735},
+		Tags:  nostr.TagMap{"e": []string{id}},
+	})
+	if err != nil {
+		return err
+	}
+	if len(rs) == 0 {
+		fmt.Println("Nothing zaps.")
+		return nil
+	}
+
+	var total int64
+	count, malformed := 0, 0
+	sums := make(map[string]int64)
+	for _, r := range rs {
+		t := r.Tags.GetFirst([]string{"bolt11", ""})
+		if t == nil {
+			malformed++
+			continue
+		}
+		msat, err := bolt11Amount(t.Value())
+		if err != nil {
+			malformed++
+			continue
+		}
+		total += msat
+		count++
+		sums[zapSender(r)] += msat
+	}
+
+	fmt.Printf("%d sats in %d zaps\n", total/1000, count)
+	if malformed > 0 {
+		fmt.Printf("%d receipts skipped for a missing or unreadable bolt11.\n", malformed)
+	}
+	var zappers []string
+	for pk := range sums {
+		if pk != "" {
+			zappers = append(zappers, pk)
+		}
+	}
+	sort.Slice(zappers, func(i, j int) bool {
+		if sums[zappers[i]] != sums[zappers[j]] {
+			return sums[zappers[i]] > sums[zappers[j]]
+		}
+		return zappers[i] < zappers[j]
+	})
+	if len(zappers) > 10 {
+		zappers = zappers[:10]
+	}
+	names := getProfileNames(zappers, rl)
+	for _, pk := range zappers {
+		npub, _ := nip19.EncodePublicKey(pk)
+		fmt.Println(strings.TrimRight(fmt.Sprintf("%10d %s %s", sums[pk]/1000, npub, names[pk]), " "))
+	}
+	if a := sums[""]; a > 0 {
+		fmt.Printf("%10d (anonymous)\n", a/1000)
+	}
+	return nil
+}
+
+// zapSender returns the key that sent the zap. The receipt itself is signed
+// by the LNURL server, so the key comes from the zap request it embeds.
+func zapSender(r *nostr.Event) string {
+	if t := r.Tags.GetFirst([]string{"description", ""}); t != nil {
+		var req nostr.Event
+		if err := json.Unmarshal([]byte(t.Value()), &req); err == nil && is64HexString(req.PubKey) {
+			return req.PubKey
+		}
+	}
+	if t := r.Tags.GetFirst([]string{"P", ""}); t != nil && is64HexString(t.Value()) {
+		return t.Value()
+	}
+	return ""
+}
+
+// bolt11Amount returns the amount of the invoice in millisatoshis. It is
+// read from the human readable part, e.g. lnbc2500u is 2500 micro BTC.
+func bolt11Amount(invoice string) (int64, error) {
+	s := strings.TrimPrefix(strings.ToLower(invoice), "lightning:")
+	n := strings.LastIndex(s, "1")
+	if !strings.HasPrefix(s, "ln") || n < 0 {
+		return 0, errors.New("Invalid bolt11: " + invoice)
+	}
+	hrp := s[2:n]
+	i := strings.IndexAny(hrp, "0123456789")
+	if i < 0 {
+		return 0, errors.New("No amount in bolt11: " + invoice)
+	}
+	a := hrp[i:]
+	// msat per unit of the multiplier, 1 BTC being 10^11 msat
+	mul, div := int64(100000000000), int64(1)
+	switch a[len(a)-1] {
+	case 'm':
+		mul = 100000000
+	case 'u':
+		mul = 100000
+	case 'n':
+		mul = 100
+	case 'p':
+		mul, div = 1, 10
+	}
+	if strings.ContainsAny(a[len(a)-1:], "munp") {
+		a = a[:len(a)-1]
+	}
+	v, err := strconv.ParseInt(a, 10, 64)
+	if err != nil || v <= 0 || v%div != 0 {
+		return 0, errors.New("Invalid amount in bolt11: " + invoice)
+	}
+	return v * mul / div, nil
+}
+
+// }}}
+
+/*
+showStats {{{
+*/
+func showStats(args []string) error {
+	var pk string
+	var err error
+	if len(args) > 0 {
+		pk, err = decodePubKey(args[0])
+	} else {
+		pk, err = readPublicKey()
+	}
+	if err != nil {
+		return err
+	}
+
+	var rl []string
+	if err := getReadRelays(&rl); err != nil {
+		fmt.Println("Nothing relay list. Make a relay list.")
+		return err
+	}
+
+	cl, err := queryEvents(rl, nostr.Filter{
+		Kinds:   []int{nostr.KindContactList},
+		Authors: []string{pk},
+		Limit:   1,
+	})
+	if err != nil {
+		return err
+	}
+	following := 0
+	if len(cl) > 0 {
+		// queryEvents returns the newest first
+		following = len(cl[0].Tags.GetAll([]string{"p", ""}))
+	}
+
+	fs, err := queryEvents(rl, nostr.Filter{
+		Kinds: []int{nostr.KindContactList},
+		Tags:  nostr.TagMap{"p": []string{pk}},
+		Limit: 5000,
+	})
+	if err != nil {
+		return err
+	}
+	followers := make(map[string]bool)
+	for _, ev := range fs {
+		followers[ev.PubKey] = true
+	}
+
+	fmt.Printf("following : %d\n", following)
+	fmt.Printf("followers : %d (estimate)\n", len(followers))
+	fmt.Println("Follower count depends on what your read relays store.")
+	return nil
+}
+
+// }}}
+
+/*
+publishEvent {{{
+*/
+// publishEvent sends a signed event to every relay in rl. Relays that cannot
+// be reached or do not accept the event are queued in pending.ndjson so that
+// "nostk flush" can retry them later.
+func publishEvent(ev nostr.Event, rl []string) error {
+	// a caller that changed the event after signEvent would be rejected by
+	// every relay, so stop before sending it anywhere
+	if ok, err := ev.CheckSignature(); err != nil || !ok || ev.ID != ev.GetID() {
+		return errors.New("Event is not signed: " + ev.ID)
+	}
+	if draftMode {
+		rl = nil
+		if err := getDraftRelayList(&rl); err != nil {
+			fmt.Println("Nothing draft relay list. Make " + draftRelays + ".")
+			return err
+		}
+	} else if err := checkWriteRelays(); err != nil {
+		return err
+	}
+	if publishTopic != "" {
+		var err error
+		if rl, err = filterRelaysByTopic(rl, publishTopic); err != nil {
+			return err
+		}
+	}
+	rl = pinRelays(rl)
+	if len(rl) == 0 {
+		if onlyRelay != "" {
+			return errors.New("No relay to publish to with --only-relay")
+		}
+		return errors.New("No relay to publish to")
+	}
+	// publish to every relay at once so a slow one does not hold up the rest
+	var okRelays []string
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, url := range rl {
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+			err := publishToRelay(url, ev)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				fmt.Println(err)
+				// flush would only be refused again
+				if reason := rejectionReason(err); !isPermanentRejection(reason) {
+					if err := appendPending(url, ev, reason); err != nil {
+						fmt.Println(err)
+					}
+				}
+				return
+			}
+			okRelays = append(okRelays, url)
+			if !quietSuccess {
+				fmt.Printf("published to %s\n", url)
+			}
+		}(url)
+	}
+	wg.Wait()
+	sort.Strings(okRelays)
+	if quietSuccess {
+		fmt.Printf("published to %d/%d relays\n", len(okRelays), len(rl))
+	}
+	if len(okRelays) == 0 {
+		return errors.New("Failed to publish to every relay")
+	}
+	if verifyPublish {
+		verifyStored(ev.ID, okRelays)
+	}
+	saveLastEvent(ev)
+	return nil
+}
+
+// filterRelaysByTopic keeps the relays that list topic in their "topics"
+// in relays.json.
+func filterRelaysByTopic(rl []string, topic string) ([]string, error) {
+	p := make(map[string]RwFlag)
+	if err := getRelayMap(p); err != nil {
+		return nil, err
+	}
+	var ret []string
+	for _, url := range rl {
+		if containsString(p[url].Topics, topic) {
+			ret = append(ret, url)
+		}
+	}
+	if len(ret) == 0 {
+		return nil, errors.New("No relay has the topic " + topic)
+	}
+	return ret, nil
+}
+
+func containsString(a []string, s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyStored asks each relay that accepted the event to serve it back,
+// since some relays answer OK and drop the event anyway.
+func verifyStored(id string, rl []string) {
+	for _, url := range rl {
+		evs, _ := queryEvents([]string{url}, nostr.Filter{IDs: []string{id}})
+		if len(evs) > 0 && evs[0].ID == id {
+			fmt.Printf("stored on %s\n", url)
+		} else {
+			fmt.Printf("not stored on %s\n", url)
+		}
+	}
+}
+
+func readLastEvent() (LastEvent, error) {
+	var le LastEvent
+	d, err := getDir()
+	if err != nil {
+		return le, err
+	}
+	b, err := os.ReadFile(d + "/" + lastEventFile)
+	if err != nil {
+		return le, err
+	}
+	err = json.Unmarshal(b, &le)
+	return le, err
+}
+
+func saveLastEvent(ev nostr.Event) {
+	b, err := json.Marshal(LastEvent{ev.ID, ev.Kind, ev.CreatedAt, ev.Tags, ev.Content})
+	if err != nil {
+		return
+	}
+	d, err := getDir()
+	if err != nil {
+		return
+	}
+	os.WriteFile(d+"/"+lastEventFile, b, 0600)
+}
+
+func publishToRelay(url string, ev nostr.Event) (err error) {
+	if err := checkOnlyRelay(url); err != nil {
+		return err
+	}
+	if err := checkRelayAllowed(url); err != nil {
+		return err
+	}
+	start := time.Now()
+	defer func() {
+		recordRelayHealth(url, time.Since(start), err)
+	}()
+	if wireDump {
+		return publishToRelayWire(url, ev)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), relayTimeout)
+	defer cancel()
+	relay, err := connectRelay(ctx, url)
+	if err != nil {
+		if terr := timeoutError(ctx, url); terr != nil {
+			return terr
+		}
+		return err
+	}
+	defer closeRelay(relay)
+	trace(url, "EVENT %s sent", ev.ID)
+	st, err := relay.Publish(ctx, ev)
+	if terr := timeoutError(ctx, url); terr != nil && st != nostr.PublishStatusSucceeded {
+		return terr
+	}
+	if err != nil {
+		trace(url, "OK false: %v", err)
+		// go-nostr reports the reason of an OK false as "msg: <reason>"
+		if st == nostr.PublishStatusFailed && strings.HasPrefix(err.Error(), "msg: ") {
+			return &rejectError{url, strings.TrimPrefix(err.Error(), "msg: ")}
+		}
+		return fmt.Errorf("%s: %w", url, err)
+	}
+	if st != nostr.PublishStatusSucceeded {
+		trace(url, "no OK received (%s)", st)
+		return fmt.Errorf("%s: %s", url, st)
+	}
+	trace(url, "OK true")
+	return nil
+}
+
+// }}}
+
+/*
+connectRelay {{{
+*/
+func connectRelay(ctx context.Context, url string) (*nostr.Relay, error) {
+	if err := checkOnlyRelay(url); err != nil {
+		return nil, err
+	}
+	if err := checkRelayAllowed(url); err != nil {
+		return nil, err
+	}
+	trace(url, "dial start")
+	relay, err := nostr.RelayConnect(ctx, url, nostr.WithNoticeHandler(func(n string) {
+		trace(url, "NOTICE %s", n)
+	}))
+	if err != nil {
+		trace(url, "dial failed: %v", err)
+		return nil, err
+	}
+	trace(url, "connection established")
+	return relay, nil
+}
+
+func closeRelay(relay *nostr.Relay) {
+	relay.Close()
+	trace(relay.URL, "closed")
+}
+
+// trace writes one timestamped line to stderr when --trace is given.
+func trace(url string, format string, a ...any) {
+	if !traceMode {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s [%s] %s\n", time.Now().Format("15:04:05.000"), url, fmt.Sprintf(format, a...))
+}
+
+// pinRelays drops every relay but the one given by --only-relay, including
+// relays found in events such as the write relays of follows.
+func pinRelays(rl []string) []string {
+	if onlyRelay == "" {
+		return rl
+	}
+	for _, u := range rl {
+		if normalizeRelayURL(u) == onlyRelay {
+			return []string{onlyRelay}
+		}
+	}
+	return nil
+}
+
+// checkRelayListWritable keeps commands that rewrite relays.json from
+// saving the single relay that --only-relay puts in place of the list.
+func checkRelayListWritable() error {
+	if onlyRelay != "" {
+		return errors.New("Relay list is not changed with --only-relay")
+	}
+	return nil
+}
+
+// timeoutError reports a relay that did not answer within --timeout, so
+// that it is shown as skipped rather than as a broken connection.
+func timeoutError(ctx context.Context, url string) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%s: skipped, no answer within %v", url, relayTimeout)
+	}
+	return nil
+}
+
+func checkOnlyRelay(url string) error {
+	if onlyRelay != "" && normalizeRelayURL(url) != onlyRelay {
+		return errors.New(url + ": skipped by --only-relay")
+	}
+	return nil
+}
+
+var errRelayNotAllowed = errors.New("skipped by NOSTK_RELAY_DENY or NOSTK_RELAY_ALLOW")
+
+// checkRelayAllowed keeps relay hints from events and pointers under
+// NOSTK_RELAY_DENY and NOSTK_RELAY_ALLOW too, not only relays.json.
+func checkRelayAllowed(url string) error {
+	if !isRelayAllowed(url) {
+		return fmt.Errorf("%s: %w", url, errRelayNotAllowed)
+	}
+	return nil
+}
+
+// }}}
+
+/*
+publishToRelayWire {{{
+*/
+// publishToRelayWire publishes over a bare websocket connection so that the
+// frames can be printed exactly as they were sent and received.
+func publishToRelayWire(url string, ev nostr.Event) error {
+	ctx, cancel := context.WithTimeout(context.Background(), relayTimeout)
+	defer cancel()
+	conn, err := nostr.NewConnection(ctx, url, nil)
+	if err != nil {
+		if terr := timeoutError(ctx, url); terr != nil {
+			return terr
+		}
+		return fmt.Errorf("%s: %w", url, err)
+	}
+	defer conn.Close()
+
+	b, err := nostr.EventEnvelope{Event: ev}.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s > %s\n", url, b)
+	if err := conn.WriteMessage(b); err != nil {
+		return fmt.Errorf("%s: %w", url, err)
+	}
+	for {
+		msg, err := conn.ReadMessage(ctx)
+		if err != nil {
+			if terr := timeoutError(ctx, url); terr != nil {
+				return terr
+			}
+			return fmt.Errorf("%s: no OK received: %w", url, err)
+		}
+		fmt.Printf("%s < %s\n", url, msg)
+		ok, isOK := nostr.ParseMessage(msg).(*nostr.OKEnvelope)
+		if !isOK || ok.EventID != ev.ID {
+			continue
+		}
+		if !ok.OK {
+			reason := ""
+			if ok.Reason != nil {
+				reason = *ok.Reason
+			}
+			return &rejectError{url, reason}
+		}
+		return nil
+	}
+}
+
+// }}}
+
+/*
+appendPending {{{
+*/
+// rejectError is returned when the relay answered OK false.
+type rejectError struct {
+	Relay  string
+	Reason string
+}
+
+func (e *rejectError) Error() string {
+	return e.Relay + ": rejected: " + e.Reason
+}
+
+func rejectionReason(err error) string {
+	var re *rejectError
+	if errors.As(err, &re) {
+		return re.Reason
+	}
+	return ""
+}
+
+// isPermanentRejection tells whether the relay will refuse the event again,
+// judged by the machine-readable prefix of the OK message in NIP-01.
+func isPermanentRejection(reason string) bool {
+	prefix, _, ok := strings.Cut(reason, ":")
+	if !ok {
+		return false
+	}
+	switch prefix {
+	case "invalid", "pow", "blocked", "restricted", "duplicate":
+		return true
+	}
+	return false
+}
+
+func appendPending(url string, ev nostr.Event, reason string) error {
+	b, err := json.Marshal(PendingEvent{url, ev, reason})
+	if err != nil {
+		return err
+	}
+	return appendLine(pending, string(b))
+}
+
+// appendLine adds one line to the file in the nostk directory.
+func appendLine(name string, l string) error {
+	d, err := getDir()
+	if err != nil {
+		return err
+	}
+	fp, err := os.OpenFile(d+"/"+name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	_, err = fp.Write([]byte(l + "\n"))
+	return err
+}
+
+// }}}
+
+/*
+flushPending {{{
+*/
+func flushPending() error {
+	d, err := getDir()
+	if err != nil {
+		return err
+	}
+	path := d + "/" + pending
+	b, err := os.ReadFile(path)
+	if err != nil || strings.TrimSpace(string(b)) == "" {
+		fmt.Println("Nothing pending events.")
+		return nil
+	}
+
+	var left []string
+	rejectedNum := 0
+	for _, l := range strings.Split(string(b), "\n") {
+		if l == "" {
+			continue
+		}
+		var pe PendingEvent
+		if err := json.Unmarshal([]byte(l), &pe); err != nil {
+			fmt.Println(err)
+			left = append(left, l)
+			continue
+		}
+		// the relay refused this very event, sending it again will not
+		// help; keep it aside instead of retrying forever
+		if !isPermanentRejection(pe.Reason) {
+			err := publishToRelay(pe.Relay, pe.Event)
+			if err == nil {
+				fmt.Printf("published %s to %s\n", pe.Event.ID, pe.Relay)
+				continue
+			}
+			fmt.Println(err)
+			// the relay is not to be used any more, so the entry would
+			// stay forever
+			if errors.Is(err, errRelayNotAllowed) {
+				fmt.Printf("dropped %s to %s\n", pe.Event.ID, pe.Relay)
+				continue
+			}
+			pe.Reason = rejectionReason(err)
+			if b, err := json.Marshal(pe); err == nil {
+				l = string(b)
+			}
+		}
+		if isPermanentRejection(pe.Reason) {
+			if err := appendLine(rejected, l); err == nil {
+				rejectedNum++
+				continue
+			}
+		}
+		left = append(left, l)
+	}
+
+	if rejectedNum > 0 {
+		fmt.Printf("%d events were rejected by relays, moved to %s\n", rejectedNum, rejected)
+	}
+	if len(left) == 0 {
+		return os.Remove(path)
+	}
+	fmt.Printf("%d events are still pending\n", len(left))
+	return os.WriteFile(path, []byte(strings.Join(left, "\n")+"\n"), 0600)
+}
+
+// }}}
+
+/*
+getAddr {{{
+*/
+func getAddr(args []string) error {
+	if len(args) < 1 {
+		fmt.Println("Nothing naddr.")
+		return errors.New("Not set naddr")
+	}
+	prefix, v, err := nip19.Decode(args[0])
+	if err != nil {
+		return err
+	}
+	if prefix != "naddr" {
+		return errors.New("Not an naddr: " + args[0])
+	}
+	ep := v.(nostr.EntityPointer)
+
+	var rl []string
+	if err := getReadRelays(&rl); err != nil {
+		fmt.Println("Nothing relay list. Make a relay list.")
+		return err
+	}
+	rl = withRelayHints(ep.Relays, rl)
+
+	evs, err := queryEvents(rl, nostr.Filter{
+		Kinds:   []int{ep.Kind},
+		Authors: []string{ep.PublicKey},
+		Tags:    nostr.TagMap{"d": []string{ep.Identifier}},
+	})
+	if err != nil {
+		return err
+	}
+	if len(evs) == 0 {
+		fmt.Println("Not found event.")
+		return errors.New("Not found event")
+	}
+	return printJSON(evs[0])
+}
+
+// }}}
+
+/*
+printJSON {{{
+*/
+// printJSON prints v as JSON formatted by --indent or --compact.
+func printJSON(v any) error {
+	var b []byte
+	var err error
+	if jsonIndent == "" {
+		b, err = json.Marshal(v)
+	} else {
+		b, err = json.MarshalIndent(v, "", jsonIndent)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+// }}}
+
+/*
+getEmojiSet {{{
+*/
+const kindEmojiSet = 30030
+
+func getEmojiSet(args []string) error {
+	imp := hasOption(&args, "--import")
+	if len(args) < 1 {
+		fmt.Println("Nothing emoji set address.")
+		return errors.New("Not set naddr")
+	}
+
+	var ep nostr.EntityPointer
+	if strings.HasPrefix(args[0], "naddr1") {
+		_, v, err := nip19.Decode(args[0])
+		if err != nil {
+			return err
+		}
+		ep = v.(nostr.EntityPointer)
+		if ep.Kind != kindEmojiSet {
+			return fmt.Errorf("naddr is kind %d, not an emoji set", ep.Kind)
+		}
+	} else {
+		if len(args) < 2 {
+			fmt.Println("Nothing emoji set identifier.")
+			return errors.New("Not set identifier")
+		}
+		pk, err := decodePubKey(args[0])
+		if err != nil {
+			return err
+		}
+		ep = nostr.EntityPointer{PublicKey: pk, Kind: kindEmojiSet, Identifier: args[1]}
+	}
+
+	var rl []string
+	if err := getReadRelays(&rl); err != nil {
+		fmt.Println("Nothing relay list. Make a relay list.")
+		return err
+	}
+	rl = withRelayHints(ep.Relays, rl)
+
+	evs, err := queryEvents(rl, nostr.Filter{
+		Kinds:   []int{kindEmojiSet},
+		Authors: []string{ep.PublicKey},
+		Tags:    nostr.TagMap{"d": []string{ep.Identifier}},
+	})
+	if err != nil {
+		return err
+	}
+	if len(evs) == 0 {
+		fmt.Println("Not found emoji set.")
+		return errors.New("Not found emoji set")
+	}
+
+	es := make(map[string]string)
+	for _, t := range evs[0].Tags.GetAll([]string{"emoji", ""}) {
+		if len(t) < 3 {
+			continue
+		}
+		es[t[1]] = t[2]
+		fmt.Printf("%s %s\n", t[1], t[2])
+	}
+	if !imp {
+		return nil
+	}
+
+	ts := make(map[string]string)
+	if err := getCustomEmoji(&ts); err != nil {
+		fmt.Println("Not found custom emoji list. Use \"nostk init\"")
+		return err
+	}
+	n := 0
+	for k, v := range es {
+		if _, ok := ts[k]; ok {
+			fmt.Printf("%s is already in custom emoji list\n", k)
+			continue
+		}
+		ts[k] = v
+		n++
+	}
+	if err := writeCustomEmojiList(ts); err != nil {
+		return err
+	}
+	fmt.Printf("imported %d emoji\n", n)
+	return nil
+}
+
+// }}}
+
+/*
+relay health {{{
+*/
+func readRelayHealth(h map[string]RelayHealth) error {
+	d, err := getDir()
+	if err != nil {
+		return err
+	}
+	b, err := os.ReadFile(d + "/" + health)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(b, &h)
+}
+
+var healthMu sync.Mutex
+
+func recordRelayHealth(url string, dur time.Duration, rerr error) {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	h := make(map[string]RelayHealth)
+	if err := readRelayHealth(h); err != nil {
+		return
+	}
+	r := h[url]
+	if rerr != nil {
+		r.Failure++
+		r.LastError = rerr.Error()
+	} else {
+		r.Success++
+		r.LatencyMs += dur.Milliseconds()
+	}
+	r.LastSeen = time.Now().Unix()
+	h[url] = r
+
+	b, err := json.Marshal(h)
+	if err != nil {
+		return
+	}
+	d, err := getDir()
+	if err != nil {
+		return
+	}
+	os.WriteFile(d+"/"+health, b, 0600)
+}
+
+func showRelayHealth() error {
+	h := make(map[string]RelayHealth)
+	if err := readRelayHealth(h); err != nil {
+		return err
+	}
+	if len(h) == 0 {
+		fmt.Println("Nothing relay health records.")
+		return nil
+	}
+	rate := func(r RelayHealth) float64 {
+		return float64(r.Success) / float64(r.Success+r.Failure)
+	}
+	avg := func(r RelayHealth) int64 {
+		if r.Success == 0 {
+			return 0
+		}
+		return r.LatencyMs / int64(r.Success)
+	}
+	l := make([]string, 0, len(h))
+	for url := range h {
+		l = append(l, url)
+	}
+	sort.Slice(l, func(i, j int) bool {
+		a, b := h[l[i]], h[l[j]]
+		if rate(a) != rate(b) {
+			return rate(a) > rate(b)
+		}
+		if avg(a) != avg(b) {
+			return avg(a) < avg(b)
+		}
+		return l[i] < l[j]
+	})
+	for _, url := range l {
+		r := h[url]
+		fmt.Printf("%5.1f%% ok:%d ng:%d avg:%dms %s\n", rate(r)*100, r.Success, r.Failure, avg(r), url)
+		if r.Failure > 0 && r.LastError != "" {
+			fmt.Printf("       last error: %s\n", r.LastError)
+		}
+	}
+	return nil
+}
+
+// }}}
+
+/*
+convertKeys {{{
+*/
+func convertKeys(args []string) error {
+	to, _ := getOption(&args, "--to")
+	switch to {
+	case "", "hex", "npub", "note", "nevent":
+	default:
+		return errors.New("Unknown conversion target: " + to)
+	}
+	if len(args) < 1 {
+		fmt.Println("Nothing input file. Use \"-\" for standard input.")
+		return errors.New("Not set input file")
+	}
+
+	var in *os.File
+	if args[0] == "-" {
+		in = os.Stdin
+	} else {
+		fp, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer fp.Close()
+		in = fp
+	}
+
+	sc := bufio.NewScanner(in)
+	for sc.Scan() {
+		l := strings.TrimSpace(sc.Text())
+		if l == "" {
+			continue
+		}
+		r, err := convertKey(l, to)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", l, err)
+			fmt.Println()
+			continue
+		}
+		fmt.Println(r)
+	}
+	return sc.Err()
+}
+
+// convertKey turns a bech32 entity into hex, or hex into npub, unless to
+// selects another target. An nsec becomes its public key for npub; other
+// conversions between keys and event ids are refused.
+func convertKey(s string, to string) (string, error) {
+	h := s
+	// what h holds: "pub", "sec", "event", or "" for bare hex
+	class := ""
+	if !is64HexString(s) {
+		prefix, v, err := nip19.Decode(s)
+		if err != nil {
+			return "", err
+		}
+		switch prefix {
+		case "npub":
+			h, class = v.(string), "pub"
+		case "nsec":
+			h, class = v.(string), "sec"
+		case "note":
+			h, class = v.(string), "event"
+		case "nprofile":
+			h, class = v.(nostr.ProfilePointer).PublicKey, "pub"
+		case "nevent":
+			h, class = v.(nostr.EventPointer).ID, "event"
+		default:
+			return "", errors.New("Cannot convert " + prefix)
+		}
+		if to == "" {
+			to = "hex"
+		}
+	} else if to == "" {
+		to = "npub"
+	}
+
+	switch to {
+	case "npub":
+		switch class {
+		case "sec":
+			pk, err := nostr.GetPublicKey(h)
+			if err != nil {
+				return "", err
+			}
+			return nip19.EncodePublicKey(pk)
+		case "event":
+			return "", errors.New("Cannot convert an event id to npub")
+		}
+		return nip19.EncodePublicKey(h)
+	case "note", "nevent":
+		if class == "pub" || class == "sec" {
+			return "", errors.New("Cannot convert a key to " + to)
+		}
+		if to == "note" {
+			return nip19.EncodeNote(h)
+		}
+		return nip19.EncodeEvent(h, nil, "")
+	}
+	return h, nil
+}
+
+// }}}
+
+/*
+encodeEntity {{{
+*/
+// encodeEntity prints the NIP-19 form of a hex key or id. Relays given after
+// the hex become hints of nevent and nprofile.
+func encodeEntity(args []string) error {
+	author, _ := getOption(&args, "--author")
+	if len(args) < 2 {
+		fmt.Println("Usage: nostk encode <npub|nsec|note|nevent|nprofile> <hex> [relay...]")
+		return errors.New("Not set type or hex")
+	}
+	typ, h, hints := args[0], args[1], args[2:]
+	if !is64HexString(h) {
+		return errors.New("Not a 64 character hex: " + h)
+	}
+	if author != "" && !is64HexString(author) {
+		return errors.New("Not a 64 character hex: " + author)
+	}
+	for _, u := range hints {
+		if !isRelayURL(u) {
+			return errors.New("Invalid relay URL: " + u)
+		}
+	}
+	if len(hints) > 0 && typ != "nevent" && typ != "nprofile" {
+		return errors.New("Relay hints are only for nevent and nprofile")
+	}
+
+	var r string
+	var err error
+	switch typ {
+	case "npub":
+		r, err = nip19.EncodePublicKey(h)
+	case "nsec":
+		r, err = nip19.EncodePrivateKey(h)
+	case "note":
+		r, err = nip19.EncodeNote(h)
+	case "nevent":
+		r, err = nip19.EncodeEvent(h, hints, author)
+	case "nprofile":
+		r, err = nip19.EncodeProfile(h, hints)
+	default:
+		return errors.New("Unknown encoding type: " + typ)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println(r)
+	return nil
+}
+
+// }}}
+
+/*
+decodeEntity {{{
+*/
+// decodeEntity prints the prefix of a NIP-19 entity and what it holds: the
+// hex for keys and notes, the pointer as JSON otherwise.
+func decodeEntity(args []string) error {
+	if len(args) < 1 {
+		fmt.Println("Usage: nostk decode <bech32>")
+		return errors.New("Not set bech32 string")
+	}
+	prefix, v, err := nip19.Decode(strings.TrimPrefix(args[0], "nostr:"))
+	if err != nil {
+		return err
+	}
+	fmt.Println(prefix)
+	if h, ok := v.(string); ok {
+		fmt.Println(h)
+		return nil
+	}
+	return printJSON(v)
+}
+
+// }}}
+
+/*
+nextReplaceableTime {{{
+*/
+// nextReplaceableTime returns a created_at newer than the replaceable event
+// of kind that the relays already hold, so that a lagging local clock cannot
+// make relays keep the old version.
+func nextReplaceableTime(pk string, kind int, rl []string) nostr.Timestamp {
+	now := eventNow()
+	evs, err := queryEvents(rl, nostr.Filter{
+		Kinds:   []int{kind},
+		Authors: []string{pk},
+		Limit:   1,
+	})
+	if err != nil || len(evs) == 0 {
+		return now
+	}
+	last := evs[0].CreatedAt
+	if last > now {
+		fmt.Printf("Warning: the current kind %d event on relays is dated in the future (%s).\n",
+			kind, last.Time().Format(time.RFC3339))
+	}
+	if last >= now {
+		return last + 1
+	}
+	return now
+}
+
+// }}}
+
+/*
+showTimeline {{{
+*/
+func showTimeline(args []string) error {
+	noReplies, repliesOnly, err := getReplyFilter(&args)
+	if err != nil {
+		return err
+	}
+	limit := 20
+	if l, ok := getOption(&args, "--limit"); ok {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 {
+			return errors.New("Invalid limit: " + l)
+		}
+		limit = n
+	}
+	f := nostr.Filter{
+		Kinds: []int{nostr.KindTextNote},
+		Limit: limit,
+	}
+	if a, ok := getOption(&args, "--author"); ok {
+		pk, err := decodePubKey(a)
+		if err != nil {
+			return err
+		}
+		f.Authors = []string{pk}
+	}
+	var rl []string
+	if err := getReadRelays(&rl); err != nil {
+		fmt.Println("Nothing relay list. Make a relay list.")
+		return err
+	}
+
+	// each relay is read until EOSE or the relay timeout
+	evs, err := queryEvents(rl, f)
+	if err != nil {
+		return err
+	}
+	evs = filterReplies(evs, noReplies, repliesOnly)
+	if len(evs) > limit {
+		evs = evs[:limit]
+	}
+	if len(evs) == 0 {
+		fmt.Println("Nothing notes.")
+		return nil
+	}
+	for _, ev := range evs {
+		printEvent(ev)
+	}
+	return nil
+}
+
+// }}}
+
+/*
+outboxTimeline {{{
+*/
+func outboxTimeline(args []string) error {
+	noReplies, repliesOnly, err := getReplyFilter(&args)
+	if err != nil {
+		return err
+	}
+	count := 20
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			return errors.New("Invalid count: " + args[0])
+		}
+		count = n
+	}
+
+	pk, err := readPublicKey()
+	if err != nil {
+		return err
+	}
+	var rl []string
+	if err := getReadRelays(&rl); err != nil {
+		fmt.Println("Nothing relay list. Make a relay list.")
+		return err
+	}
+
+	follows, err := getFollows(pk, rl)
+	if err != nil {
+		return err
+	}
+	if len(follows) == 0 {
+		fmt.Println("Nothing follows.")
+		return nil
+	}
+
+	// group the authors by the relays they write to
+	wr := getWriteRelaysOf(follows, rl)
+	byRelay := make(map[string][]string)
+	for _, a := range follows {
+		urls := wr[a]
+		if len(urls) == 0 {
+			urls = rl
+		}
+		for _, url := range urls {
+			byRelay[url] = append(byRelay[url], a)
+		}
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	seen := make(map[string]bool)
+	var evs []*nostr.Event
+	for url, authors := range byRelay {
+		wg.Add(1)
+		go func(url string, authors []string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			es, _ := queryEvents([]string{url}, nostr.Filter{
+				Kinds:   []int{nostr.KindTextNote},
+				Authors: authors,
+				Limit:   count,
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			for _, ev := range es {
+				if !seen[ev.ID] {
+					seen[ev.ID] = true
+					evs = append(evs, ev)
+				}
+			}
+		}(url, authors)
+	}
+	wg.Wait()
+
+	sort.Slice(evs, func(i, j int) bool {
+		return evs[i].CreatedAt > evs[j].CreatedAt
+	})
+	evs = filterReplies(evs, noReplies, repliesOnly)
+	if len(evs) > count {
+		evs = evs[:count]
+	}
+	for _, ev := range evs {
+		printEvent(ev)
+	}
+	return nil
+}
+
+// getReplyFilter removes --no-replies and --replies-only from args.
+func getReplyFilter(args *[]string) (noReplies bool, repliesOnly bool, err error) {
+	noReplies = hasOption(args, "--no-replies")
+	repliesOnly = hasOption(args, "--replies-only")
+	if noReplies && repliesOnly {
+		return false, false, errors.New("Cannot use --no-replies with --replies-only")
+	}
+	return noReplies, repliesOnly, nil
+}
+
+// filterReplies keeps top-level notes or replies only. A relay filter cannot
+// express "has no e tag", so this is done after fetching.
+func filterReplies(evs []*nostr.Event, noReplies bool, repliesOnly bool) []*nostr.Event {
+	if !noReplies && !repliesOnly {
+		return evs
+	}
+	var ret []*nostr.Event
+	for _, ev := range evs {
+		if isReply(ev) == repliesOnly {
+			ret = append(ret, ev)
+		}
+	}
+	return ret
+}
+
+func isReply(ev *nostr.Event) bool {
+	return ev.Kind == nostr.KindTextNote && ev.Tags.GetFirst([]string{"e", ""}) != nil
+}
+
+// }}}
+
+/*
+getFollows {{{
+*/
+func getFollows(pk string, rl []string) ([]string, error) {
+	cl, err := queryEvents(rl, nostr.Filter{
+		Kinds:   []int{nostr.KindContactList},
+		Authors: []string{pk},
+		Limit:   1,
+	})
+	if err != nil || len(cl) == 0 {
+		return nil, err
+	}
+	var follows []string
+	for _, t := range cl[0].Tags.GetAll([]string{"p", ""}) {
+		if is64HexString(t.Value()) {
+			follows = append(follows, t.Value())
+		}
+	}
+	return follows, nil
+}
+
+// }}}
+
+/*
+follow {{{
+*/
+func follow(args []string) error {
+	yes := hasOption(&args, "--yes")
+	if len(args) < 1 {
+		fmt.Println("Not set npub.")
+		return errors.New("Not set public key")
+	}
+	target, err := decodePubKey(args[0])
+	if err != nil {
+		return err
+	}
+	t := nostr.Tag{"p", target}
+	if len(args) > 1 {
+		t = append(t, normalizeRelayURL(args[1]))
+	}
+	if len(args) > 2 {
+		t = append(t, args[2])
+	}
+
+	cl, err := readContactList()
+	if err != nil {
+		return err
+	}
+	if cl == nil {
+		// relays that were down may hold the real list, which this would replace
+		fmt.Println("Not found your follow list on the read relays.")
+		if !yes && !confirm("Start a new follow list?") {
+			return nil
+		}
+		cl = &nostr.Event{Tags: nostr.Tags{}}
+	}
+	for _, p := range cl.Tags.GetAll([]string{"p", ""}) {
+		if p.Value() == target {
+			fmt.Println("Already following " + args[0] + ".")
+			return nil
+		}
+	}
+	return publishContactList(cl.Content, append(cl.Tags, t))
+}
+
+// }}}
+
+/*
+unfollow {{{
+*/
+func unfollow(args []string) error {
+	if len(args) < 1 {
+		fmt.Println("Not set npub.")
+		return errors.New("Not set public key")
+	}
+	target, err := decodePubKey(args[0])
+	if err != nil {
+		return err
+	}
+	cl, err := readContactList()
+	if err != nil {
+		return err
+	}
+	if cl == nil {
+		fmt.Println("Not found your follow list on the read relays.")
+		return errors.New("No contact list")
+	}
+	tags := nostr.Tags{}
+	for _, t := range cl.Tags {
+		if t.Key() == "p" && t.Value() == target {
+			continue
+		}
+		tags = append(tags, t)
+	}
+	if len(tags) == len(cl.Tags) {
+		fmt.Println("Not following " + args[0] + ".")
+		return errors.New("Not in follow list")
+	}
+	return publishContactList(cl.Content, tags)
+}
+
+// }}}
+
+/*
+listFollows {{{
+*/
+func listFollows() error {
+	cl, err := readContactList()
+	if err != nil {
+		return err
+	}
+	if cl == nil || len(cl.Tags.GetAll([]string{"p", ""})) == 0 {
+		fmt.Println("Nothing follows.")
+		return nil
+	}
+	for _, t := range cl.Tags.GetAll([]string{"p", ""}) {
+		npub, err := nip19.EncodePublicKey(t.Value())
+		if err != nil {
+			npub = t.Value()
+		}
+		// the relay hint and petname follow the key when set
+		fmt.Println(strings.TrimRight(strings.Join(append([]string{npub}, t[2:]...), " "), " "))
+	}
+	return nil
+}
+
+// readContactList returns your newest kind 3 on the read relays, or nil if
+// none was found.
+func readContactList() (*nostr.Event, error) {
+	pk, err := readPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	var rl []string
+	if err := getReadRelays(&rl); err != nil {
+		fmt.Println("Nothing relay list. Make a relay list.")
+		return nil, err
+	}
+	evs, err := queryEvents(rl, nostr.Filter{
+		Kinds:   []int{nostr.KindContactList},
+		Authors: []string{pk},
+		Limit:   1,
+	})
+	if err != nil || len(evs) == 0 {
+		return nil, err
+	}
+	return evs[0], nil
+}
+
+// publishContactList publishes a new kind 3 with tags. The content is kept,
+// as old clients store their relays in it.
+func publishContactList(content string, tags nostr.Tags) error {
+	sk, pk, err := readKeyPair()
+	if err != nil {
+		return err
+	}
+	var rl []string
+	if err := getWriteRelays(&rl); err != nil {
+		fmt.Println("Nothing relay list. Make a relay list.")
+		return err
+	}
+	ev := nostr.Event{
+		PubKey:    pk,
+		CreatedAt: nextReplaceableTime(pk, nostr.KindContactList, rl),
+		Kind:      nostr.KindContactList,
+		Tags:      tags,
+		Content:   content,
+	}
+	if err := signEvent(&ev, sk); err != nil {
+		return err
+	}
+	return publishEvent(ev, rl)
+}
+
+// }}}
+
+/*
+exportFollows {{{
+*/
+func exportFollows(args []string) error {
+	format, ok := getOption(&args, "--format")
+	if !ok {
+		format = "csv"
+	}
+	if format != "csv" && format != "ndjson" {
+		return errors.New("Unknown export format: " + format)
+	}
+	if len(args) < 1 {
+		fmt.Println("Nothing export file.")
+		return errors.New("Not set export file")
+	}
+	pk, err := readPublicKey()
+	if err != nil {
+		return err
+	}
+	var rl []string
+	if err := getReadRelays(&rl); err != nil {
+		fmt.Println("Nothing relay list. Make a relay list.")
+		return err
+	}
+	follows, err := getFollows(pk, rl)
+	if err != nil {
+		return err
+	}
+	if len(follows) == 0 {
+		fmt.Println("Nothing follows.")
+		return nil
+	}
+	// a follow list may have the same key twice
+	follows = subtractKeys(follows, nil)
+	// follows without a profile are exported with an empty name
+	profiles := getProfiles(follows, rl)
+
+	fp, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	cw := csv.NewWriter(fp)
+	if format == "csv" {
+		cw.Write([]string{"pubkey", "npub", "name", "display_name", "nip05"})
+	}
+	for _, f := range follows {
+		npub, _ := nip19.EncodePublicKey(f)
+		p := profiles[f]
+		if format == "csv" {
+			cw.Write([]string{f, npub, p.Name, p.DisplayName, p.NIP05})
+			continue
+		}
+		b, err := json.Marshal(map[string]string{
+			"pubkey":       f,
+			"npub":         npub,
+			"name":         p.Name,
+			"display_name": p.DisplayName,
+			"nip05":        p.NIP05,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := fp.Write(append(b, '\n')); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	fmt.Printf("exported %d follows to %s\n", len(follows), args[0])
+	return nil
+}
+
+// }}}
+
+/*
+publishReply {{{
+*/
+func publishReply(args []string) error {
+	if len(args) < 1 {
+		fmt.Println("Not set event id.")
+		return errors.New("Not set event id")
+	}
+	id, err := resolveEventID(args[0])
+	if err != nil {
+		return err
+	}
+	args = args[1:]
+	tgs := nostr.Tags{}
+	if err := setMessageOptions(&args, &tgs); err != nil {
+		return err
+	}
+	buff, err := readMessage(&args)
+	if err != nil {
+		return err
+	}
+
+	var rl []string
+	if err := getReadRelays(&rl); err != nil {
+		fmt.Println("Nothing relay list. Make a relay list.")
+		return err
+	}
+	evs, err := queryEvents(rl, nostr.Filter{IDs: []string{id}})
+	if err != nil {
+		return err
+	}
+	if len(evs) == 0 {
+		fmt.Println("Not found the note to reply to on any relay.")
+		return errors.New("Not found event: " + id)
+	}
+	pk, err := readPublicKey()
+	if err != nil {
+		return err
+	}
+	_, err = publishMessage(buff, append(replyTags(evs[0], pk), tgs...))
+	return err
+}
+
+// replyTags returns the NIP-10 tags of a reply to parent: marked root and
+// reply e tags, and p tags for the parent author and everyone it mentions.
+func replyTags(parent *nostr.Event, me string) nostr.Tags {
+	tgs := nostr.Tags{}
+	if root := nip10.GetThreadRoot(parent.Tags); root != nil && root.Value() != parent.ID {
+		relay := ""
+		if len(*root) > 2 {
+			relay = (*root)[2]
+		}
+		tgs = append(tgs, nostr.Tag{"e", root.Value(), relay, "root"})
+		tgs = append(tgs, nostr.Tag{"e", parent.ID, "", "reply"})
+	} else {
+		tgs = append(tgs, nostr.Tag{"e", parent.ID, "", "root"})
+	}
+	pks := []string{parent.PubKey}
+	for _, t := range parent.Tags.GetAll([]string{"p", ""}) {
+		pks = append(pks, t.Value())
+	}
+	for _, p := range subtractKeys(pks, []string{me}) {
+		if is64HexString(p) {
+			tgs = append(tgs, nostr.Tag{"p", p})
+		}
+	}
+	return tgs
+}
+
+// }}}
+
+/*
+publishMessageTo {{{
+*/
+func publishMessageTo(args []string) error {
+	if len(args) < 1 {
+		fmt.Println("Not set npub.")
+		return errors.New("Not set public key")
+	}
+	pk, err := decodePubKey(args[0])
+	if err != nil {
+		return err
+	}
+	args = args[1:]
+	tgs := nostr.Tags{}
+	if err := setMessageOptions(&args, &tgs); err != nil {
+		return err
+	}
+	buff, err := readMessage(&args)
+	if err != nil {
+		return err
+	}
+	_, err = publishMessage(buff, append(nostr.Tags{{"p", pk}}, tgs...))
+	return err
+}
+
+// }}}
+
+/*
+threadPost {{{
+*/
+// threadPost replies to the note posted last under root, so successive
+// calls build one chain. The last id of each chain is kept in
+// thread_chain.json.
+func threadPost(args []string) error {
+	if len(args) < 1 {
+		fmt.Println("Not set root event id.")
+		return errors.New("Not set root event id")
+	}
+	root, err := resolveEventID(args[0])
+	if err != nil {
+		return err
+	}
+	args = args[1:]
+	tgs := nostr.Tags{}
+	if err := setMessageOptions(&args, &tgs); err != nil {
+		return err
+	}
+	buff, err := readMessage(&args)
+	if err != nil {
+		return err
+	}
+
+	d, err := getDir()
+	if err != nil {
+		return err
+	}
+	path := d + "/" + threadChain
+	chain := make(map[string]string)
+	if b, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(b, &chain); err != nil {
+			return err
+		}
+	}
+	etgs := nostr.Tags{{"e", root, "", "root"}}
+	if last := chain[root]; last != "" {
+		etgs = append(etgs, nostr.Tag{"e", last, "", "reply"})
+	}
+	id, err := publishMessage(buff, append(etgs, tgs...))
+	if err != nil {
+		return err
+	}
+	chain[root] = id
+	b, err := json.Marshal(chain)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0600)
+}
+
+// }}}
+
+/*
+doctor {{{
+*/
+// doctor checks every file in the nostk directory and tells how to fix
+// what is wrong, without touching the network.
+func doctor() error {
+	d, err := getDir()
+	if err != nil {
+		return err
+	}
+	failed := 0
+	result := func(name string, err error, fix string) {
+		if err != nil {
+			fmt.Printf("FAIL %s: %v\n", name, err)
+			fmt.Printf("     -> %s\n", fix)
+			failed++
+			return
+		}
+		fmt.Printf("PASS %s\n", name)
+	}
+	readFile := func(name string) (string, error) {
+		b, err := os.ReadFile(d + "/" + name)
+		return strings.TrimSpace(string(b)), err
+	}
+
+	// keys
+	const fixKey = "run \"nostk genkey\" or restore the key files from a backup"
+	pk, err := readFile(hpub)
+	if err == nil && !nostr.IsValidPublicKeyHex(pk) {
+		err = errors.New("not a hex public key")
+	}
+	result(hpub, err, fixKey)
+	if np, err := readFile(npub); err == nil {
+		prefix, v, derr := nip19.Decode(np)
+		if derr != nil {
+			err = derr
+		} else if prefix != "npub" {
+			err = errors.New("not an npub")
+		} else if v.(string) != pk {
+			err = errors.New("does not match " + hpub)
+		}
+		result(npub, err, fixKey)
+	} else {
+		result(npub, err, fixKey)
+	}
+	if os.Getenv("NOSTK_SIGNER_CMD") != "" {
+		fmt.Println("SKIP secret keys: NOSTK_SIGNER_CMD is set")
+	} else {
+		sk, err := readFile(hsec)
+		encrypted := strings.HasPrefix(sk, "ncryptsec1")
+		if err == nil {
+			if encrypted {
+				// checking it against .hpub needs the passphrase
+				prefix, bits, derr := bech32.DecodeNoLimit(sk)
+				if derr != nil || prefix != "ncryptsec" || len(bits)*5/8 != 91 {
+					err = errors.New("broken ncryptsec")
+				}
+			} else if !is64HexString(sk) {
+				err = fmt.Errorf("not 64 hex characters (%d)", len(sk))
+			} else if p, _ := nostr.GetPublicKey(sk); p != pk {
+				err = errors.New("does not match " + hpub)
+			}
+		}
+		result(hsec, err, fixKey)
+		ns, err := readFile(nsec)
+		if encrypted {
+			if err == nil {
+				result(nsec, errors.New("plaintext key next to an encrypted "+hsec), "remove "+nsec)
+			}
+		} else {
+			if err == nil {
+				prefix, v, derr := nip19.Decode(ns)
+				if derr != nil {
+					err = derr
+				} else if prefix != "nsec" {
+					err = errors.New("not an nsec")
+				} else if v.(string) != sk {
+					err = errors.New("does not match " + hsec)
+				}
+			}
+			result(nsec, err, fixKey)
+		}
+	}
+
+	// profile
+	b, err := readFile(profile)
+	if err == nil {
+		var p ProfileMetadata
+		err = json.Unmarshal([]byte(b), &p)
+	}
+	result(profile, err, "run \"nostk init\" or fix the JSON with \"nostk editProfile\"")
+
+	// relays
+	b, err = readFile(relays)
+	if err == nil {
+		rm := make(map[string]RwFlag)
+		if err = json.Unmarshal([]byte(b), &rm); err == nil {
+			var bad []string
+			writable := false
+			for k, v := range rm {
+				u, perr := url.Parse(k)
+				if perr != nil || (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+					bad = append(bad, strconv.Quote(k))
+				}
+				writable = writable || v.Write
+			}
+			sort.Strings(bad)
+			if len(rm) == 0 {
+				err = errors.New("no relays")
+			} else if len(bad) > 0 {
+				err = errors.New("invalid relay URL: " + strings.Join(bad, ", "))
+			} else if !writable {
+				err = errors.New("no write relay")
+			}
+		}
+	}
+	result(relays, err, "fix the list with \"nostk editRelays\"")
+
+	// custom emoji
+	b, err = readFile(emoji)
+	if err == nil {
+		em := make(map[string]string)
+		if err = json.Unmarshal([]byte(b), &em); err == nil {
+			var bad []string
+			for k, v := range em {
+				u, perr := url.Parse(v)
+				if perr != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+					bad = append(bad, strconv.Quote(k))
+				}
+			}
+			sort.Strings(bad)
+			if len(bad) > 0 {
+				err = errors.New("invalid image URL for " + strings.Join(bad, ", "))
+			}
+		}
+	}
+	result(emoji, err, "fix the list with \"nostk editEmoji\"")
+
+	if failed > 0 {
+		return fmt.Errorf("doctor found %d problems", failed)
+	}
+	fmt.Println("All checks passed.")
+	return nil
+}
+
+// }}}
+
+/*
+pullProfile {{{
+*/
+func pullProfile() error {
+	pk, err := readPublicKey()
+	if err != nil {
+		return err
+	}
+	var rl []string
+	if err := getReadRelays(&rl); err != nil {
+		fmt.Println("Nothing relay list. Make a relay list.")
+		return err
+	}
+	evs, err := queryEvents(rl, nostr.Filter{
+		Kinds:   []int{nostr.KindSetMetadata},
+		Authors: []string{pk},
+		Limit:   1,
+	})
+	if err != nil {
+		return err
+	}
+	if len(evs) == 0 {
+		fmt.Println("Not found your profile on relays.")
+		return errors.New("Not found profile")
+	}
+	if !json.Valid([]byte(evs[0].Content)) {
+		return errors.New("Published profile is not JSON")
+	}
+	d, err := getDir()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(d+"/"+profile, []byte(evs[0].Content), 0644); err != nil {
+		return err
+	}
+	// publishProfile keeps the next version newer than this one
+	ts := strconv.FormatInt(int64(evs[0].CreatedAt), 10)
+	if err := os.WriteFile(d+"/"+profileTime, []byte(ts), 0644); err != nil {
+		return err
+	}
+	fmt.Println("pulled profile of " + evs[0].CreatedAt.Time().Format(time.RFC3339))
+	return nil
+}
+
+// readProfileTime returns created_at of the profile pulled last, or 0.
+func readProfileTime() nostr.Timestamp {
+	d, err := getDir()
+	if err != nil {
+		return 0
+	}
+	b, err := os.ReadFile(d + "/" + profileTime)
+	if err != nil {
+		return 0
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return nostr.Timestamp(n)
+}
+
+// }}}
+
+/*
+getProfile {{{
+*/
+func getProfile(args []string) error {
+	if len(args) < 1 {
+		fmt.Println("Not set npub.")
+		return errors.New("Not set public key")
+	}
+	pk, err := decodePubKey(args[0])
+	if err != nil {
+		return err
+	}
+	var rl []string
+	if err := getReadRelays(&rl); err != nil {
+		fmt.Println("Nothing relay list. Make a relay list.")
+		return err
+	}
+	// an nprofile also tells where the profile can be found
+	if prefix, v, err := nip19.Decode(args[0]); err == nil && prefix == "nprofile" {
+		rl = withRelayHints(v.(nostr.ProfilePointer).Relays, rl)
+	}
+
+	evs, err := queryEvents(rl, nostr.Filter{
+		Kinds:   []int{nostr.KindSetMetadata},
+		Authors: []string{pk},
+	})
+	if err != nil {
+		return err
+	}
+	if len(evs) == 0 {
+		fmt.Println("Not found the profile on the relays.")
+		return errors.New("No profile event")
+	}
+	// queryEvents puts the newest first
+	ev := evs[0]
+	var p ProfileMetadata
+	if err := json.Unmarshal([]byte(ev.Content), &p); err != nil {
+		return err
+	}
+	npub, _ := nip19.EncodePublicKey(pk)
+	fields := []struct {
+		name  string
+		value string
+	}{
+		{"npub", npub},
+		{"name", p.Name},
+		{"display_name", p.DisplayName},
+		{"about", p.About},
+		{"website", p.Website},
+		{"picture", p.Picture},
+		{"banner", p.Banner},
+		{"nip05", p.NIP05},
+		{"lud16", p.LUD16},
+		{"created_at", ev.CreatedAt.Time().Format("2006-01-02 15:04:05")},
+	}
+	for _, f := range fields {
+		if f.value != "" {
+			fmt.Printf("%-13s %s\n", f.name+":", displayContent(f.value))
+		}
+	}
+	return nil
+}
+
+// }}}
+
+/*
+showActive {{{
+*/
+func showActive(args []string) error {
+	days := 30
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			return errors.New("Invalid days: " + args[0])
+		}
+		days = n
+	}
+	pk, err := readPublicKey()
+	if err != nil {
+		return err
+	}
+	var rl []string
+	if err := getReadRelays(&rl); err != nil {
+		fmt.Println("Nothing relay list. Make a relay list.")
+		return err
+	}
+	follows, err := getFollows(pk, rl)
+	if err != nil {
+		return err
+	}
+	if len(follows) == 0 {
+		fmt.Println("Nothing follows.")
+		return nil
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	last := make(map[string]nostr.Timestamp)
+	for _, a := range follows {
+		wg.Add(1)
+		go func(a string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			evs, _ := queryEvents(rl, nostr.Filter{
+				Kinds:   []int{nostr.KindTextNote},
+				Authors: []string{a},
+				Limit:   1,
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			if len(evs) > 0 {
+				last[a] = evs[0].CreatedAt
+			}
+		}(a)
+	}
+	wg.Wait()
+
+	// a follow list may have the same key twice
+	authors := subtractKeys(follows, nil)
+	sort.SliceStable(authors, func(i, j int) bool {
+		return last[authors[i]] > last[authors[j]]
+	})
+	names := getProfileNames(authors, rl)
+	limit := nostr.Timestamp(time.Now().Add(-time.Duration(days) * 24 * time.Hour).Unix())
+	for _, a := range authors {
+		npub, _ := nip19.EncodePublicKey(a)
+		when := "never"
+		if t, ok := last[a]; ok {
+			when = t.Time().Format("2006-01-02 15:04:05")
+		}
+		mark := " "
+		if last[a] < limit {
+			mark = "!"
+		}
+		fmt.Println(strings.TrimRight(mark+" "+when+" "+npub+" "+names[a], " "))
+	}
+	return nil
+}
+
+// }}}
+
+/*
+showThread {{{
+*/
+func showThread(args []string) error {
+	depth := 5
+	if d, ok := getOption(&args, "--depth"); ok {
+		n, err := strconv.Atoi(d)
+		if err != nil || n < 0 {
+			return errors.New("Invalid depth: " + d)
+		}
+		depth = n
+	}
+	if len(args) < 1 {
+		fmt.Println("Not set event id.")
+		return errors.New("Not set event id")
+	}
+	id, err := resolveEventID(args[0])
+	if err != nil {
+		return err
+	}
+	var rl []string
+	if err := getReadRelays(&rl); err != nil {
+		fmt.Println("Nothing relay list. Make a relay list.")
+		return err
+	}
+
+	// start from the root of the thread the note belongs to
+	rootID := id
+	evs, _ := queryEvents(rl, nostr.Filter{IDs: []string{id}})
+	if len(evs) > 0 {
+		if t := nip10.GetThreadRoot(evs[0].Tags); t != nil && is64HexString(t.Value()) {
+			rootID = t.Value()
+		}
+	}
+	events := make(map[string]*nostr.Event)
+	if rootID != id {
+		evs, _ = queryEvents(rl, nostr.Filter{IDs: []string{rootID}})
+	}
+	if len(evs) > 0 && evs[0].ID == rootID {
+		events[rootID] = evs[0]
+	}
+
+	// replies to each level are fetched by their e tags, one level at a time
+	level := []string{rootID}
+	for d := 0; d < depth && len(level) > 0; d++ {
+		evs, err := queryEvents(rl, nostr.Filter{
+			Kinds: []int{nostr.KindTextNote},
+			Tags:  nostr.TagMap{"e": level},
+		})
+		if err != nil {
+			return err
+		}
+		level = nil
+		for _, ev := range evs {
+			if _, ok := events[ev.ID]; !ok && ev.ID != rootID {
+				events[ev.ID] = ev
+				level = append(level, ev.ID)
+			}
+		}
+	}
+
+	children := make(map[string][]*nostr.Event)
+	var authors []string
+	for _, ev := range events {
+		authors = append(authors, ev.PubKey)
+		if ev.ID == rootID {
+			continue
+		}
+		parent := rootID
+		if t := nip10.GetImmediateReply(ev.Tags); t != nil {
+			if _, ok := events[t.Value()]; ok {
+				parent = t.Value()
+			}
+		}
+		children[parent] = append(children[parent], ev)
+	}
+	for _, c := range children {
+		sort.Slice(c, func(i, j int) bool {
+			return c[i].CreatedAt < c[j].CreatedAt
+		})
+	}
+	names := getProfileNames(authors, rl)
+
+	if root, ok := events[rootID]; ok {
+		printThreadEvent(root, 0, names)
+	} else {
+		fmt.Println("(root note " + rootID + " not found)")
+		fmt.Println()
+	}
+	var walk func(id string, d int)
+	walk = func(id string, d int) {
+		if d > depth {
+			return
+		}
+		for _, ev := range children[id] {
+			printThreadEvent(ev, d, names)
+			walk(ev.ID, d+1)
+		}
+	}
+	walk(rootID, 1)
+	return nil
+}
+
+func printThreadEvent(ev *nostr.Event, d int, names map[string]string) {
+	indent := strings.Repeat("  ", d)
+	who := names[ev.PubKey]
+	if who == "" {
+		who, _ = nip19.EncodePublicKey(ev.PubKey)
+	}
+	fmt.Printf("%s%s %s\n", indent, who, ev.CreatedAt.Time().Format("2006-01-02 15:04:05"))
+	for _, l := range strings.Split(displayContent(limitedContent(ev.Content)), "\n") {
+		fmt.Println(indent + l)
+	}
+	fmt.Println()
+}
+
+// }}}
+
+/*
+showTopic {{{
+*/
+func showTopic(args []string) error {
+	count := 20
+	if l, ok := getOption(&args, "--limit"); ok {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 {
+			return errors.New("Invalid limit: " + l)
+		}
+		count = n
+	}
+	if len(args) > 1 {
+		if n, err := strconv.Atoi(args[len(args)-1]); err == nil {
+			if n < 1 {
+				return errors.New("Invalid count: " + args[len(args)-1])
+			}
+			count = n
+			args = args[:len(args)-1]
+		}
+	}
+	if len(args) < 1 {
+		fmt.Println("Not set hashtag.")
+		return errors.New("Not set hashtag")
+	}
+	// setHashTags stores t tags in lower case without the mark
+	var tags []string
+	for _, a := range args {
+		t := strings.ToLower(strings.TrimLeft(a, "#＃﹟"))
+		if t != "" {
+			tags = append(tags, t)
+		}
+	}
+	var rl []string
+	if err := getReadRelays(&rl); err != nil {
+		fmt.Println("Nothing relay list. Make a relay list.")
+		return err
+	}
+
+	evs, err := queryEvents(rl, nostr.Filter{
+		Kinds: []int{nostr.KindTextNote},
+		Tags:  nostr.TagMap{"t": tags},
+		Limit: count,
+	})
+	if err != nil {
+		return err
+	}
+	if len(evs) > count {
+		evs = evs[:count]
+	}
+	for _, ev := range evs {
+		printEvent(ev)
+	}
+	return nil
+}
+
+// }}}
+
+/*
+checkMyNip05 {{{
+*/
+func checkMyNip05() error {
+	s, err := readProfile()
+	if err != nil {
+		fmt.Println("Not found your profile. Use \"nostk init\" and \"nostk editProfile\".")
+		return err
+	}
+	var p ProfileMetadata
+	if err := json.Unmarshal([]byte(s), &p); err != nil {
+		return err
+	}
+	if p.NIP05 == "" {
+		fmt.Println("Not set nip05 in your profile.")
+		return errors.New("Not set nip05")
+	}
+	pk, err := readPublicKey()
+	if err != nil {
+		return err
+	}
+	if err := verifyNip05(p.NIP05, pk); err != nil {
+		return err
+	}
+	fmt.Println(p.NIP05 + " points to your key.")
+	return nil
+}
+
+// verifyNip05 checks that the nostr.json of the domain of nip05 maps its
+// name to pk.
+func verifyNip05(nip05 string, pk string) error {
+	name, domain := splitNip05(nip05)
+	names, header, err := fetchNip05Names(name, domain)
+	if err != nil {
+		return err
+	}
+	if header.Get("Access-Control-Allow-Origin") == "" {
+		fmt.Println("warning: no Access-Control-Allow-Origin header, web clients cannot verify you")
+	}
+	got, ok := names[name]
+	if !ok {
+		return fmt.Errorf("Name %q is missing in nostr.json of %s", name, domain)
+	}
+	if got != pk {
+		return fmt.Errorf("Name %q points to %s, but your key is %s", name, got, pk)
+	}
+	return nil
+}
+
+// }}}
+
+/*
+encodeNevent {{{
+*/
+func encodeNevent(args []string) error {
+	if len(args) < 1 {
+		fmt.Println("Not set event id.")
+		return errors.New("Not set event id")
+	}
+	id, err := resolveEventID(args[0])
+	if err != nil {
+		return err
+	}
+	pk, err := readPublicKey()
+	if err != nil {
+		return err
+	}
+	var rl []string
+	if err := getWriteRelays(&rl); err != nil {
+		fmt.Println("Nothing relay list. Make a relay list.")
+		return err
+	}
+	// a couple of hints are enough to find the note, more only make the
+	// link longer
+	if len(rl) > 2 {
+		rl = rl[:2]
+	}
+	nevent, err := nip19.EncodeEvent(id, rl, pk)
+	if err != nil {
+		return err
+	}
+	fmt.Println(nevent)
+	fmt.Println("nostr:" + nevent)
+	return nil
+}
+
+// }}}
+
+/*
+followDiff {{{
+*/
+func followDiff(args []string) error {
+	if len(args) < 2 {
+		fmt.Println("Not set two users.")
+		return errors.New("Not set two users")
+	}
+	pka, err := decodePubKey(args[0])
+	if err != nil {
+		return err
+	}
+	pkb, err := decodePubKey(args[1])
+	if err != nil {
+		return err
+	}
+	var rl []string
+	if err := getReadRelays(&rl); err != nil {
+		fmt.Println("Nothing relay list. Make a relay list.")
+		return err
+	}
+
+	fa, err := getFollows(pka, rl)
+	if err != nil {
+		return err
+	}
+	fb, err := getFollows(pkb, rl)
+	if err != nil {
+		return err
+	}
+	onlyA := subtractKeys(fa, fb)
+	onlyB := subtractKeys(fb, fa)
+	names := getProfileNames(append(append([]string{}, onlyA...), onlyB...), rl)
+
+	fmt.Printf("Followed by %s only (%d):\n", args[0], len(onlyA))
+	for _, pk := range onlyA {
+		printPubKeyName(pk, names)
+	}
+	fmt.Printf("Followed by %s only (%d):\n", args[1], len(onlyB))
+	for _, pk := range onlyB {
+		printPubKeyName(pk, names)
+	}
+	return nil
+}
+
+// subtractKeys returns the keys of a missing in b, without duplicates.
+func subtractKeys(a []string, b []string) []string {
+	seen := make(map[string]bool)
+	for _, k := range b {
+		seen[k] = true
+	}
+	var ret []string
+	for _, k := range a {
+		if !seen[k] {
+			seen[k] = true
+			ret = append(ret, k)
+		}
+	}
+	return ret
+}
+
+// getProfileNames returns the display name, or the name, from the newest
+// kind-0 of each author.
+func getProfileNames(authors []string, rl []string) map[string]string {
+	names := make(map[string]string)
+	for pk, p := range getProfiles(authors, rl) {
+		if p.DisplayName != "" {
+			names[pk] = p.DisplayName
+		} else {
+			names[pk] = p.Name
+		}
+	}
+	return names
+}
+
+// getProfiles returns the newest kind-0 of each author. Authors without a
+// readable profile are left out.
+func getProfiles(authors []string, rl []string) map[string]ProfileMetadata {
+	profiles := make(map[string]ProfileMetadata)
+	if len(authors) == 0 {
+		return profiles
+	}
+	evs, _ := queryEvents(rl, nostr.Filter{
+		Kinds:   []int{nostr.KindSetMetadata},
+		Authors: authors,
+	})
+	// queryEvents puts the newest first
+	for _, ev := range evs {
+		if _, ok := profiles[ev.PubKey]; ok {
+			continue
+		}
+		var p ProfileMetadata
+		if err := json.Unmarshal([]byte(ev.Content), &p); err != nil {
+			continue
+		}
+		profiles[ev.PubKey] = p
+	}
+	return profiles
+}
+
+func printPubKeyName(pk string, names map[string]string) {
+	npub, err := nip19.EncodePublicKey(pk)
+	if err != nil {
+		npub = pk
+	}
+	if name := names[pk]; name != "" {
+		fmt.Println("  " + npub + " " + name)
+	} else {
+		fmt.Println("  " + npub)
+	}
+}
+
+// }}}
+
+/*
+getWriteRelaysOf {{{
+*/
+// getWriteRelaysOf returns the write relays announced in the kind-10002 of
+// each author. Lists are cached in relay_lists.json for a day.
+func getWriteRelaysOf(authors []string, rl []string) map[string][]string {
+	cache := make(map[string]CachedRelayList)
+	d, err := getDir()
+	if err == nil {
+		if b, err := os.ReadFile(d + "/" + relayListCache); err == nil {
+			json.Unmarshal(b, &cache)
+		}
+	}
+
+	var missing []string
+	for _, a := range authors {
+		if c, ok := cache[a]; !ok || time.Now().Unix()-c.FetchedAt > 24*60*60 {
+			missing = append(missing, a)
+		}
+	}
+	if len(missing) > 0 {
+		evs, _ := queryEvents(rl, nostr.Filter{
+			Kinds:   []int{nostr.KindRelayListMetadata},
+			Authors: missing,
+		})
+		now := time.Now().Unix()
+		for _, a := range missing {
+			cache[a] = CachedRelayList{FetchedAt: now}
+		}
+		done := make(map[string]bool)
+		// newest first, so the first list of each author wins
+		for _, ev := range evs {
+			if done[ev.PubKey] {
+				continue
+			}
+			done[ev.PubKey] = true
+			var urls []string
+			for _, t := range ev.Tags.GetAll([]string{"r", ""}) {
+				if len(t) > 2 && t[2] == "read" {
+					continue
+				}
+				urls = append(urls, t.Value())
+			}
+			cache[ev.PubKey] = CachedRelayList{Relays: urls, FetchedAt: now}
+		}
+		if b, err := json.Marshal(cache); err == nil && d != "" {
+			os.WriteFile(d+"/"+relayListCache, b, 0600)
+		}
+	}
+
+	wr := make(map[string][]string)
+	for _, a := range authors {
+		for _, url := range cache[a].Relays {
+			if isRelayAllowed(url) {
+				wr[a] = append(wr[a], url)
+			}
+		}
+	}
+	return wr
+}
+
+// }}}
+
+/*
+printEvent {{{
+*/
+func printEvent(ev *nostr.Event) {
+	npub, _ := nip19.EncodePublicKey(ev.PubKey)
+	fmt.Printf("%s %s\n", npub, ev.CreatedAt.Time().Format("2006-01-02 15:04:05"))
+	fmt.Println(displayContent(limitedContent(ev.Content)))
+	fmt.Println()
+}
+
+func limitedContent(c string) string {
+	if limitContent > 0 {
+		if r := []rune(c); len(r) > limitContent {
+			c = string(r[:limitContent]) + "…"
+		}
+	}
+	return c
+}
+
+// displayContent escapes everything but printable ASCII when
+// --escape-unicode is given, which exposes hidden and homoglyph characters.
+func displayContent(s string) string {
+	if !escapeUnicode {
+		return s
+	}
+	var sb strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\n' || r == '\t' || (r >= 0x20 && r < 0x7f):
+			sb.WriteRune(r)
+		case r > 0xffff:
+			fmt.Fprintf(&sb, "\\U%08X", r)
+		default:
+			fmt.Fprintf(&sb, "\\u%04X", r)
+		}
+	}
+	return sb.String()
+}
+
+// }}}
+
+/*
+measurePropagation {{{
+*/
+func measurePropagation(args []string) error {
+	timeout := 30 * time.Second
+	// --timeout is the global one for each relay, so the whole wait is --wait
+	if t, ok := getOption(&args, "--wait"); ok {
+		n, err := strconv.Atoi(t)
+		if err != nil || n < 1 {
+			return errors.New("Invalid wait: " + t)
+		}
+		timeout = time.Duration(n) * time.Second
+	}
+	if len(args) < 2 {
+		fmt.Println("Usage: nostk propagation <publish-relay> <read-relay>")
+		return errors.New("Not set relays")
+	}
+	for _, u := range args[:2] {
+		if !isRelayURL(u) {
+			fmt.Println("Usage: nostk propagation <publish-relay> <read-relay>")
+			return errors.New("Invalid relay URL: " + u)
+		}
+	}
+	from, to := normalizeRelayURL(args[0]), normalizeRelayURL(args[1])
+
+	// a throwaway key keeps the test note away from your identity, and the
+	// NIP-40 expiration lets relays drop it soon
+	sk := nostr.GeneratePrivateKey()
+	pk, err := nostr.GetPublicKey(sk)
+	if err != nil {
+		return err
+	}
+	now := nostr.Now()
+	ev := nostr.Event{
+		PubKey:    pk,
+		CreatedAt: now,
+		Kind:      nostr.KindTextNote,
+		Tags:      nostr.Tags{{"expiration", strconv.FormatInt(int64(now)+600, 10)}},
+		Content:   fmt.Sprintf("nostk propagation test %d", time.Now().UnixNano()),
+	}
+	if err := ev.Sign(sk); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	reader, err := connectRelay(ctx, to)
+	if err != nil {
+		return err
+	}
+	defer closeRelay(reader)
+
+	if err := publishToRelay(from, ev); err != nil {
+		return err
+	}
+	start := time.Now()
+	fmt.Printf("published %s to %s\n", ev.ID, from)
+
+	for {
+		qctx, qcancel := context.WithTimeout(ctx, 5*time.Second)
+		es, err := reader.QuerySync(qctx, nostr.Filter{IDs: []string{ev.ID}})
+		qcancel()
+		if err != nil {
+			return err
+		}
+		if len(es) > 0 {
+			fmt.Printf("seen on %s after %v\n", to, time.Since(start).Round(time.Millisecond))
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			fmt.Printf("not seen on %s within %v\n", to, timeout)
+			return errors.New("Propagation timed out")
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// }}}
+
+/*
+pullRelayList {{{
+*/
+func pullRelayList(args []string) error {
+	yes := hasOption(&args, "--yes")
+	if err := checkRelayListWritable(); err != nil {
+		return err
+	}
+
+	pk, err := readPublicKey()
+	if err != nil {
+		return err
+	}
+	p := make(map[string]RwFlag)
+	if err := getRelayMap(p); err != nil {
+		fmt.Println("Not found relay list. Use \"nostk init\"")
+		return err
+	}
+	var rl []string
+	if err := getRelayList(&rl); err != nil {
+		return err
+	}
+
+	evs, err := queryEvents(rl, nostr.Filter{
+		Kinds:   []int{nostr.KindRelayListMetadata},
+		Authors: []string{pk},
+		Limit:   1,
+	})
+	if err != nil {
+		return err
+	}
+	if len(evs) == 0 {
+		fmt.Println("Not found your published relay list.")
+		return nil
+	}
+
+	add := make(map[string]RwFlag)
+	for _, t := range evs[0].Tags.GetAll([]string{"r", ""}) {
+		url := normalizeRelayURL(t.Value())
+		if url == "" {
+			continue
+		}
+		if _, ok := p[url]; ok {
+			continue
+		}
+		f := RwFlag{true, true, nil}
+		if len(t) > 2 {
+			switch t[2] {
+			case "read":
+				f.Write = false
+			case "write":
+				f.Read = false
+			}
+		}
+		add[url] = f
+	}
+	if len(add) == 0 {
+		fmt.Println("relays.json already has every published relay.")
+		return nil
+	}
+
+	l := make([]string, 0, len(add))
+	for url := range add {
+		l = append(l, url)
+	}
+	sort.Strings(l)
+	for _, url := range l {
+		fmt.Printf("add %v R:%v W:%v\n", url, add[url].Read, add[url].Write)
+	}
+	if !yes && !confirm("Merge these relays into relays.json?") {
+		return nil
+	}
+	for url, f := range add {
+		p[url] = f
+	}
+	return writeRelayList(p)
+}
+
+// }}}
+
+/*
+purgeFromRelay {{{
+*/
+func purgeFromRelay(args []string) error {
+	yes := hasOption(&args, "--yes")
+	if len(args) < 1 {
+		fmt.Println("Nothing relay URL.")
+		return errors.New("Not set relay URL")
+	}
+	if !isRelayURL(args[0]) {
+		return errors.New("Invalid relay URL: " + args[0])
+	}
+	url := normalizeRelayURL(args[0])
+
+	sk, pk, err := readKeyPair()
+	if err != nil {
+		return err
+	}
+
+	evs, err := queryAllEvents([]string{url}, nostr.Filter{
+		Kinds: []int{
+			nostr.KindSetMetadata,
+			nostr.KindTextNote,
+			nostr.KindContactList,
+			nostr.KindRelayListMetadata,
+		},
+		Authors: []string{pk},
+	})
+	if err != nil {
+		return err
+	}
+	if len(evs) == 0 {
+		fmt.Println("Nothing your events on " + url)
+		return nil
+	}
+
+	fmt.Printf("%d of your events were found on %s.\n", len(evs), url)
+	if !yes && !confirm("Publish deletion requests for all of them to " + url + "?") {
+		return nil
+	}
+
+	tags := nostr.Tags{}
+	for _, ev := range evs {
+		tags = append(tags, nostr.Tag{"e", ev.ID})
+		if ev.Kind != nostr.KindTextNote {
+			tags = tags.AppendUnique(nostr.Tag{"a", fmt.Sprintf("%d:%s:", ev.Kind, pk)})
+		}
+	}
+	// keep each deletion event at a size relays accept
+	const chunk = 500
+	for i := 0; i < len(tags); i += chunk {
+		j := i + chunk
+		if j > len(tags) {
+			j = len(tags)
+		}
+		ev := nostr.Event{
+			PubKey:    pk,
+			CreatedAt: eventNow(),
+			Kind:      nostr.KindDeletion,
+			Tags:      tags[i:j],
+			Content:   "",
+		}
+		if err := signEvent(&ev, sk); err != nil {
+			return err
+		}
+		if err := publishToRelay(url, ev); err != nil {
+			return err
+		}
+		fmt.Printf("published deletion request %s to %s\n", ev.ID, url)
+	}
+	return nil
+}
+
+// }}}
+
+/*
+showLastEvent {{{
+*/
+func showLastEvent() error {
+	le, err := readLastEvent()
+	if err != nil {
+		if os.IsNotExist(err) {
+			fmt.Println("Nothing published yet.")
+		}
+		return err
+	}
+	fmt.Println("id: " + le.ID)
+	if note, err := nip19.EncodeNote(le.ID); err == nil {
+		fmt.Println("note: " + note)
+	}
+	fmt.Printf("kind: %d\n", le.Kind)
+	fmt.Println("created_at: " + le.CreatedAt.Time().Format(time.RFC3339))
+	for _, t := range le.Tags {
+		fmt.Println("tag: " + strings.Join(t, " "))
+	}
+	fmt.Println()
+	fmt.Println(le.Content)
+	return nil
+}
+
+// }}}
+
+/*
+mentionsOfURL {{{
+*/
+func mentionsOfURL(args []string) error {
+	if len(args) < 1 {
+		fmt.Println("Not set URL.")
+		return errors.New("Not set URL")
+	}
+	u := normalizeWebURL(args[0])
+	if u == "" {
+		return errors.New("Invalid URL: " + args[0])
+	}
+	var rl []string
+	if err := getReadRelays(&rl); err != nil {
+		fmt.Println("Nothing relay list. Make a relay list.")
+		return err
+	}
+
+	// clients do not agree on the trailing slash, so ask for both forms
+	values := []string{u}
+	if strings.HasSuffix(u, "/") {
+		values = append(values, strings.TrimSuffix(u, "/"))
+	} else {
+		values = append(values, u+"/")
+	}
+	evs, err := queryEvents(rl, nostr.Filter{Tags: nostr.TagMap{"r": values}})
+	if err != nil {
+		return err
+	}
+	if len(evs) == 0 {
+		fmt.Println("Not found event.")
+		return nil
+	}
+	for _, ev := range evs {
+		printEvent(ev)
+	}
+	return nil
+}
+
+// normalizeWebURL lowercases the scheme and host and drops the fragment,
+// which never reaches the server anyway.
+func normalizeWebURL(s string) string {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.Fragment = ""
+	return u.String()
+}
+
+// }}}
+
+/*
+syncReplaceables {{{
+*/
+func syncReplaceables() error {
+	pk, err := readPublicKey()
+	if err != nil {
+		return err
+	}
+	var rl []string
+	if err := getRelayList(&rl); err != nil {
+		fmt.Println("Nothing relay list. Make a relay list.")
+		return err
+	}
+
+	kinds := []int{nostr.KindSetMetadata, nostr.KindContactList, nostr.KindRelayListMetadata}
+	evs, err := queryEvents(rl, nostr.Filter{Kinds: kinds, Authors: []string{pk}})
+	if err != nil {
+		return err
+	}
+	// the newest copy is looked for on every relay, but sent only to
+	// the write relays
+	var wl []string
+	if err := getWriteRelays(&wl); err != nil {
+		fmt.Println("Nothing relay list. Make a relay list.")
+		return err
+	}
+	for _, k := range kinds {
+		var latest *nostr.Event
+		// newest first
+		for _, ev := range evs {
+			if ev.Kind == k {
+				latest = ev
+				break
+			}
+		}
+		if latest == nil {
+			fmt.Printf("Not found your kind %d event.\n", k)
+			continue
+		}
+		// the signed event is sent as is, so every relay ends up with the
+		// very same copy
+		fmt.Printf("kind %d: %s\n", k, latest.ID)
+		if err := publishEvent(*latest, wl); err != nil {
+			fmt.Println(err)
+		}
+	}
+	return nil
+}
+
+// }}}
+
+/*
+confirm {{{
+*/
+func confirm(msg string) bool {
+	fmt.Printf("%s [y/N] ", msg)
+	sc := bufio.NewScanner(os.Stdin)
+	if !sc.Scan() {
+		return false
+	}
+	a := strings.ToLower(strings.TrimSpace(sc.Text()))
+	return a == "y" || a == "yes"
+}
+
+// }}}
+
+/*
+getDir {{{
+*/
+// getDir returns the directory holding keys and settings: $NOSTK_HOME if
+// set, else $XDG_CONFIG_HOME/nostk, else $HOME/.nostk. An existing
+// $HOME/.nostk still wins over a not yet created XDG one, so setting
+// XDG_CONFIG_HOME does not hide keys made before. With --profile the files
+// live in a sub directory of that name.
+func getDir() (string, error) {
+	dir := os.Getenv("NOSTK_HOME")
+	if dir == "" {
+		home := os.Getenv("HOME")
+		if x := os.Getenv("XDG_CONFIG_HOME"); x != "" {
+			dir = x + "/nostk"
+			if _, err := os.Stat(dir); err != nil && home != "" {
+				if _, err := os.Stat(home + secretDir); err == nil {
+					dir = home + secretDir
+				}
+			}
+		} else if home != "" {
+			dir = home + secretDir
+		} else {
+			return "", errors.New("Not set HOME environmental variables")
+		}
+	}
+	if profileName != "" {
+		dir += "/" + profileName
+	}
+	if _, err := os.Stat(dir); err != nil {
+		if err = os.MkdirAll(dir, 0700); err != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// }}}
+
+/*
+getRelayMap {{{
+*/
+// getRelayMap loads relays.json into p. URLs are normalized on the way so
+// differently written forms of one relay end up as a single entry.
+func getRelayMap(p map[string]RwFlag) error {
+	if onlyRelay != "" {
+		p[onlyRelay] = RwFlag{true, true, nil}
+		return nil
+	}
+	b, err := readRelayList()
+	if err != nil {
+		return err
+	}
+	// an emptied relays.json is an empty list, not broken JSON
+	if strings.TrimSpace(b) == "" {
+		return nil
+	}
+	raw := make(map[string]RwFlag)
+	if err := json.Unmarshal([]byte(b), &raw); err != nil {
+		return err
+	}
+	mergeRelayMap(p, raw)
+	return nil
+}
+
+func mergeRelayMap(p map[string]RwFlag, raw map[string]RwFlag) {
+	for k, v := range raw {
+		n := normalizeRelayURL(k)
+		f := p[n]
+		topics := f.Topics
+		for _, t := range v.Topics {
+			if !containsString(topics, t) {
+				topics = append(topics, t)
+			}
+		}
+		p[n] = RwFlag{f.Read || v.Read, f.Write || v.Write, topics}
+	}
+}
+
+// }}}
+
+/*
+normalizeRelayURL {{{
+*/
+// normalizeRelayURL lowercases the scheme and host, drops the default port
+// and strips a trailing slash from an otherwise empty path.
+func normalizeRelayURL(s string) string {
+	s = strings.TrimSpace(s)
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return s
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if (u.Scheme == "wss" && u.Port() == "443") || (u.Scheme == "ws" && u.Port() == "80") {
+		h := u.Hostname()
+		if strings.Contains(h, ":") {
+			h = "[" + h + "]"
+		}
+		u.Host = h
+	}
+	if u.Path == "/" {
+		u.Path = ""
+	}
+	return u.String()
+}
+
+// isRelayURL reports whether s is a ws:// or wss:// URL with a host.
+func isRelayURL(s string) bool {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil || u.Host == "" {
+		return false
+	}
+	scheme := strings.ToLower(u.Scheme)
+	return scheme == "ws" || scheme == "wss"
+}
+
+// withRelayHints puts the relay hints of a NIP-19 pointer before rl, so the
+// relays the author named are asked first. Hints that are not relay URLs are
+// dropped, and each relay appears once.
+func withRelayHints(hints []string, rl []string) []string {
+	var r []string
+	for _, u := range hints {
+		if isRelayURL(u) {
+			if u = normalizeRelayURL(u); !containsString(r, u) {
+				r = append(r, u)
+			}
+		}
+	}
+	for _, u := range rl {
+		if !containsString(r, u) {
+			r = append(r, u)
+		}
+	}
+	return r
+}
+
+// }}}
+
+/*
+getRelayList {{{
+*/
+// getRelayList returns every relay whatever its flags. Events are published
+// with getWriteRelays; only pubRelays and syncReplaceables send to all of
+// them, so that readers of any relay can find your lists.
+func getRelayList(rl *[]string) error {
+	p := make(map[string]RwFlag)
+	err := getRelayMap(p)
+	if err != nil {
+		return err
+	}
+	for i := range p {
+		if isRelayAllowed(i) {
+			*rl = append(*rl, i)
+		}
+	}
+	sort.Strings(*rl)
+	return nil
+}
+
+// }}}
+
+/*
+getDraftRelayList {{{
+*/
+// draft_relays.json has the same layout as relays.json and lists the private
+// relays used to preview events before they are published for real.
+func getDraftRelayList(rl *[]string) error {
+	if onlyRelay != "" {
+		*rl = []string{onlyRelay}
+		return nil
+	}
+	d, err := getDir()
+	if err != nil {
+		return err
+	}
+	b, err := os.ReadFile(d + "/" + draftRelays)
+	if err != nil {
+		return err
+	}
+	raw := make(map[string]RwFlag)
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	p := make(map[string]RwFlag)
+	mergeRelayMap(p, raw)
+	for i := range p {
+		if p[i].Write && isRelayAllowed(i) {
+			*rl = append(*rl, i)
+		}
+	}
+	sort.Strings(*rl)
+	if len(*rl) == 0 {
+		return errors.New("No write relay in " + draftRelays)
+	}
+	return nil
+}
+
+// }}}
+
+/*
+checkWriteRelays {{{
+*/
+func checkWriteRelays() error {
+	p := make(map[string]RwFlag)
+	err := getRelayMap(p)
+	if err != nil {
+		return err
+	}
+	for i := range p {
+		if p[i].Write && isRelayAllowed(i) {
+			return nil
+		}
+	}
+	return errors.New("no write relays configured; enable write on at least one relay with editRelays")
+}
+
+// }}}
+
+/*
+getReadRelays {{{
+*/
+func getReadRelays(rl *[]string) error {
+	p := make(map[string]RwFlag)
+	err := getRelayMap(p)
+	if err != nil {
+		return err
+	}
+	for i := range p {
+		if p[i].Read && isRelayAllowed(i) {
+			*rl = append(*rl, i)
+		}
+	}
+	sort.Strings(*rl)
+	return nil
+}
+
+// }}}
+
+/*
+getWriteRelays {{{
+*/
+func getWriteRelays(rl *[]string) error {
+	p := make(map[string]RwFlag)
+	err := getRelayMap(p)
+	if err != nil {
+		return err
+	}
+	for i := range p {
+		if p[i].Write && isRelayAllowed(i) {
+			*rl = append(*rl, i)
+		}
+	}
+	sort.Strings(*rl)
+	return nil
+}
+
+// }}}
+
+/*
+isRelayAllowed {{{
+*/
+// isRelayAllowed applies NOSTK_RELAY_DENY and NOSTK_RELAY_ALLOW, both
+// comma-separated host globs such as "*.example.com". A denied host is never
+// connected to; when an allowlist is set only matching hosts are used.
+func isRelayAllowed(u string) bool {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(pu.Hostname())
+	if matchHostGlobs(os.Getenv("NOSTK_RELAY_DENY"), host) {
+		return false
+	}
+	if allow := os.Getenv("NOSTK_RELAY_ALLOW"); allow != "" {
+		return matchHostGlobs(allow, host)
+	}
+	return true
+}
+
+func matchHostGlobs(globs string, host string) bool {
+	for _, g := range strings.Split(globs, ",") {
+		g = strings.ToLower(strings.TrimSpace(g))
+		if g == "" {
+			continue
+		}
+		if ok, _ := path.Match(g, host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// }}}
+
+/*
+queryEvents {{{
+*/
+func queryEvents(rl []string, f nostr.Filter) ([]*nostr.Event, error) {
+	seen := make(map[string]bool)
+	var evs []*nostr.Event
+	for _, url := range pinRelays(rl) {
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), relayTimeout)
+		relay, err := connectRelay(ctx, url)
+		if err != nil {
+			if terr := timeoutError(ctx, url); terr != nil {
+				err = terr
+			}
+			cancel()
+			recordRelayHealth(url, time.Since(start), err)
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		trace(url, "REQ %s sent", f)
+		es, err := relay.QuerySync(ctx, f)
+		trace(url, "%d events received", len(es))
+		closeRelay(relay)
+		cancel()
+		recordRelayHealth(url, time.Since(start), err)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		for _, ev := range es {
+			if seen[ev.ID] {
+				continue
+			}
+			seen[ev.ID] = true
+			evs = append(evs, ev)
+		}
+	}
+	// newest first
+	sort.Slice(evs, func(i, j int) bool {
+		return evs[i].CreatedAt > evs[j].CreatedAt
+	})
+	if discoverRelay {
+		collectRelayHints(evs)
+	}
+	return evs, nil
+}
+
+// }}}
+
+/*
+queryAllEvents {{{
+*/
+// queryAllEvents is queryEvents for more events than one answer holds:
+// relays cap the size of an answer, so it pages backwards with "until"
+// until no older event comes back.
+func queryAllEvents(rl []string, f nostr.Filter) ([]*nostr.Event, error) {
+	seen := make(map[string]bool)
+	var evs []*nostr.Event
+	f.Limit = 500
+	for {
+		es, err := queryEvents(rl, f)
+		if err != nil {
+			return nil, err
+		}
+		n := 0
+		for _, ev := range es {
+			if seen[ev.ID] {
+				continue
+			}
+			seen[ev.ID] = true
+			evs = append(evs, ev)
+			n++
+			if f.Until == nil || ev.CreatedAt <= *f.Until {
+				until := ev.CreatedAt
+				f.Until = &until
+			}
+		}
+		if n == 0 {
+			break
+		}
+	}
+	return evs, nil
+}
+
+// }}}
+
+/*
+discoverRelays {{{
+*/
+// relay hints and authors seen by queryEvents for --discover-relays
+var (
+	hintMu      sync.Mutex
+	relayHints  = make(map[string]int)
+	hintAuthors = make(map[string]bool)
+)
+
+func collectRelayHints(evs []*nostr.Event) {
+	hintMu.Lock()
+	defer hintMu.Unlock()
+	for _, ev := range evs {
+		hintAuthors[ev.PubKey] = true
+		for _, t := range ev.Tags {
+			if len(t) < 3 || (t[0] != "e" && t[0] != "p" && t[0] != "q") {
+				continue
+			}
+			if u := normalizeRelayURL(t[2]); strings.HasPrefix(u, "wss://") || strings.HasPrefix(u, "ws://") {
+				relayHints[u]++
+			}
+		}
+	}
+}
+
+// showDiscoveredRelays prints the relays from the collected hints and the
+// authors' kind-10002 that are not in relays.json yet, most used first.
+func showDiscoveredRelays() {
+	// stop collecting while the relay lists are fetched
+	discoverRelay = false
+	var rl []string
+	if err := getReadRelays(&rl); err != nil {
+		return
+	}
+	var authors []string
+	for a := range hintAuthors {
+		authors = append(authors, a)
+	}
+	if len(authors) > 0 {
+		for _, urls := range getWriteRelaysOf(authors, rl) {
+			for _, u := range urls {
+				relayHints[normalizeRelayURL(u)]++
+			}
+		}
+	}
+
+	p := make(map[string]RwFlag)
+	if err := getRelayMap(p); err != nil {
+		return
+	}
+	var found []string
+	for u := range relayHints {
+		if _, ok := p[u]; !ok {
+			found = append(found, u)
+		}
+	}
+	if len(found) == 0 {
+		return
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if relayHints[found[i]] != relayHints[found[j]] {
+			return relayHints[found[i]] > relayHints[found[j]]
+		}
+		return found[i] < found[j]
+	})
+	fmt.Println("Suggested relays:")
+	for _, u := range found {
+		fmt.Printf("  %s (%d)\n", u, relayHints[u])
+	}
+}
+
+// }}}
+
+/*
+saveRelays {{{
+*/
+func saveRelays(rl []string) error {
+	dn, err := getDir()
+	if err != nil {
+		return err
+	}
+
+	path := dn + "/" + relays
+	fp, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	for _, l := range rl {
+		_, err = fp.WriteString(l + "\n")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// }}}
+
+/*
+readPrivateKey {{{
+*/
+func readPrivateKey() (string, error) {
+	var k []string
+	dir, err := getDir()
+	if err != nil {
+		return "", err
+	}
+	path := dir + "/" + hsec
+	if _, err := os.Stat(path); err != nil {
+		return "", err
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	rs := strings.Split(string(b), "\n")
+	for _, r := range rs {
+		if strings.TrimSpace(r) != "" { // 最終行の\nにより発生する余分なレコードを排除
+			k = append(k, strings.TrimSpace(r))
+		}
+	}
+	if len(k) == 0 {
+		fmt.Println("Make a key pair with \"nostk genkey\" or \"nostk importKey\".")
+		return "", errors.New("Private key file is empty: " + path)
+	}
+	if strings.HasPrefix(k[0], "ncryptsec1") {
+		pass, err := readPassphrase("Passphrase: ")
+		if err != nil {
+			return "", err
+		}
+		return decryptNcryptsec(k[0], pass)
+	}
+	return k[0], nil
+}
+
+// readPassphrase takes NOSTK_PASSPHRASE, or reads from the terminal
+// without echo. Without a terminal it fails instead of waiting for input.
+func readPassphrase(prompt string) (string, error) {
+	if p := os.Getenv("NOSTK_PASSPHRASE"); p != "" {
+		return p, nil
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", errors.New("Private key is encrypted. Set NOSTK_PASSPHRASE or run in a terminal")
+	}
+	defer tty.Close()
+	fmt.Fprint(tty, prompt)
+	b, err := term.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(tty)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// encryptNcryptsec encrypts the hex secret key as NIP-49 describes:
+// scrypt with 2^logN rounds derives the key for XChaCha20-Poly1305.
+func encryptNcryptsec(sk string, pass string, logN uint8) (string, error) {
+	if len(sk) < 64 {
+		// GeneratePrivateKey may drop leading zero bytes
+		sk = strings.Repeat("0", 64-len(sk)) + sk
+	}
+	skb, err := hex.DecodeString(sk)
+	if err != nil || len(skb) != 32 {
+		return "", errors.New("Invalid private key")
+	}
+	salt := make([]byte, 16)
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	key, err := scrypt.Key([]byte(norm.NFKC.String(pass)), salt, 1<<logN, 8, 1, 32)
+	if err != nil {
+		return "", err
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return "", err
+	}
+	// 0x02: not known whether the key was ever handled insecurely
+	ad := []byte{0x02}
+	data := []byte{0x02, logN}
+	data = append(data, salt...)
+	data = append(data, nonce...)
+	data = append(data, ad...)
+	data = append(data, aead.Seal(nil, nonce, skb, ad)...)
+	bits, err := bech32.ConvertBits(data, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	return bech32.Encode("ncryptsec", bits)
+}
+
+func decryptNcryptsec(s string, pass string) (string, error) {
+	prefix, bits, err := bech32.DecodeNoLimit(s)
+	if err != nil {
+		return "", err
+	}
+	data, err := bech32.ConvertBits(bits, 5, 8, false)
+	if err != nil {
+		return "", err
+	}
+	if prefix != "ncryptsec" || len(data) != 91 || data[0] != 0x02 {
+		return "", errors.New("Unsupported ncryptsec")
+	}
+	salt, nonce, ad, ct := data[2:18], data[18:42], data[42:43], data[43:]
+	key, err := scrypt.Key([]byte(norm.NFKC.String(pass)), salt, 1<<data[1], 8, 1, 32)
+	if err != nil {
+		return "", err
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return "", err
+	}
+	skb, err := aead.Open(nil, nonce, ct, ad)
+	if err != nil {
+		return "", errors.New("Wrong passphrase")
+	}
+	return hex.EncodeToString(skb), nil
+}
+
+// }}}
+
+/*
+readKeyPair {{{
+*/
+// readKeyPair returns the key pair used for signing. With NOSTK_SIGNER_CMD
+// the secret stays with the external signer and only .hpub is read.
+func readKeyPair() (string, string, error) {
+	if os.Getenv("NOSTK_SIGNER_CMD") != "" {
+		d, err := getDir()
+		if err != nil {
+			return "", "", err
+		}
+		b, err := os.ReadFile(d + "/" + hpub)
+		if err != nil {
+			fmt.Println("Nothing public key. Put your public key in " + hpub + ".")
+			return "", "", err
+		}
+		pk := strings.TrimSpace(string(b))
+		if !is64HexString(pk) {
+			return "", "", errors.New("Invalid public key in " + hpub)
+		}
+		return "", pk, nil
+	}
+	sk, err := readPrivateKey()
+	if err != nil {
+		fmt.Println("Nothing key pair. Make key pair.")
+		return "", "", err
+	}
+	pk, err := nostr.GetPublicKey(sk)
+	if err != nil {
+		return "", "", err
+	}
+	return sk, pk, nil
+}
+
+// }}}
+
+/*
+readPublicKey {{{
+*/
+// readPublicKey takes the saved .hpub, so that commands which only read do
+// not need the passphrase of an encrypted key. The private key is used only
+// when .hpub is missing.
+func readPublicKey() (string, error) {
+	d, err := getDir()
+	if err != nil {
+		return "", err
+	}
+	b, err := os.ReadFile(d + "/" + hpub)
+	if errors.Is(err, os.ErrNotExist) {
+		_, pk, err := readKeyPair()
+		return pk, err
+	}
+	if err != nil {
+		return "", err
+	}
+	pk := strings.TrimSpace(string(b))
+	if !is64HexString(pk) {
+		return "", errors.New("Invalid public key in " + hpub)
+	}
+	return pk, nil
+}
+
+// }}}
+
+/*
+decodePubKey {{{
+*/
+func decodePubKey(s string) (string, error) {
+	if is64HexString(s) {
+		return s, nil
+	}
+	if strings.Contains(s, "@") {
+		return resolveNip05(s)
+	}
+	prefix, v, err := nip19.Decode(s)
+	if err != nil {
+		return "", err
+	}
+	switch prefix {
+	case "npub":
+		return v.(string), nil
+	case "nprofile":
+		return v.(nostr.ProfilePointer).PublicKey, nil
+	}
+	return "", errors.New("Not a public key: " + s)
+}
+
+// }}}
+
+/*
+is64HexString {{{
+*/
+var hex64Regexp = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+func is64HexString(s string) bool {
+	return hex64Regexp.MatchString(s)
+}
+
+// }}}
+
+/*
+resolveEventID {{{
+*/
+var hexPrefixRegexp = regexp.MustCompile(`^[0-9a-f]+$`)
+
+// resolveEventID accepts what decodeEventID does plus a git-style hex prefix
+// of at least 8 characters, which is looked up on the read relays.
+func resolveEventID(s string) (string, error) {
+	if id, err := decodeEventID(s); err == nil {
+		return id, nil
+	}
+	if !hexPrefixRegexp.MatchString(s) || len(s) > 64 {
+		return "", errors.New("Not an event id: " + s)
+	}
+	if len(s) < 8 {
+		return "", errors.New("Event id prefix is too short: " + s)
+	}
+
+	var rl []string
+	if err := getReadRelays(&rl); err != nil {
+		return "", err
+	}
+	// old relays still match "ids" by prefix; otherwise look through our
+	// own recent events.
+	evs, _ := queryEvents(rl, nostr.Filter{IDs: []string{s}})
+	if len(evs) == 0 {
+		if pk, err := readPublicKey(); err == nil {
+			evs, _ = queryEvents(rl, nostr.Filter{Authors: []string{pk}, Limit: 500})
+		}
+	}
+	var ids []string
+	for _, ev := range evs {
+		if strings.HasPrefix(ev.ID, s) {
+			ids = append(ids, ev.ID)
+		}
+	}
+	switch len(ids) {
+	case 0:
+		return "", errors.New("Not found event: " + s)
+	case 1:
+		return ids[0], nil
+	}
+	return "", fmt.Errorf("Event id prefix %s is ambiguous: %s", s, strings.Join(ids, ", "))
+}
+
+// }}}
+
+/*
+resolveNip05 {{{
+*/
+func resolveNip05(s string) (string, error) {
+	name, domain := splitNip05(s)
+	names, _, err := fetchNip05Names(name, domain)
+	if err != nil {
+		return "", err
+	}
+	if pk := names[name]; is64HexString(pk) {
+		return pk, nil
+	}
+	return "", errors.New("Not found NIP-05 identifier: " + s)
+}
+
+// splitNip05 splits name@domain. A bare domain means the "_" name.
+func splitNip05(s string) (string, string) {
+	name, domain, ok := strings.Cut(s, "@")
+	if !ok {
+		name, domain = "_", s
+	}
+	return strings.ToLower(name), domain
+}
+
+// fetchNip05Names returns the names of /.well-known/nostr.json on domain,
+// together with the response headers.
+func fetchNip05Names(name string, domain string) (map[string]string, http.Header, error) {
+	u := "https://" + domain + "/.well-known/nostr.json?name=" + url.QueryEscape(name)
+	trace(u, "GET")
+	resp, err := httpDo("GET", u)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Domain %s is unreachable: %w", domain, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("%s returned %s", u, resp.Status)
+	}
+	var wk struct {
+		Names map[string]string `json:"names"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&wk); err != nil {
+		return nil, nil, fmt.Errorf("%s is not a valid nostr.json: %w", u, err)
+	}
+	return wk.Names, resp.Header, nil
+}
+
+// }}}
+
+/*
+httpDo {{{
+*/
+// httpDo sends a request without a body. Some servers refuse the default
+// Go User-Agent, so it is set to NOSTK_USER_AGENT or nostk/<version>, and
+// NOSTK_HTTP_HEADERS adds headers written as "Name: value;Name: value".
+// Connection errors and 429/5xx answers are retried twice with backoff;
+// NOSTK_HTTP_TIMEOUT sets the timeout of each attempt in seconds.
+func httpDo(method string, u string) (*http.Response, error) {
+	timeout := 10 * time.Second
+	if t := os.Getenv("NOSTK_HTTP_TIMEOUT"); t != "" {
+		n, err := strconv.Atoi(t)
+		if err != nil || n < 1 {
+			return nil, errors.New("Invalid NOSTK_HTTP_TIMEOUT: " + t)
+		}
+		timeout = time.Duration(n) * time.Second
+	}
+	client := &http.Client{Timeout: timeout}
+
+	const attempts = 3
+	backoff := 500 * time.Millisecond
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			trace(u, "retrying in %s", backoff)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		var req *http.Request
+		req, err = http.NewRequest(method, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		ua := os.Getenv("NOSTK_USER_AGENT")
+		if ua == "" {
+			ua = "nostk/" + getVersion()
+		}
+		req.Header.Set("User-Agent", ua)
+		for _, h := range strings.Split(os.Getenv("NOSTK_HTTP_HEADERS"), ";") {
+			k, v, ok := strings.Cut(h, ":")
+			if ok && strings.TrimSpace(k) != "" {
+				req.Header.Set(strings.TrimSpace(k), strings.TrimSpace(v))
+			}
+		}
+		var resp *http.Response
+		resp, err = client.Do(req)
+		if err != nil {
+			continue
+		}
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			// keep the last answer so the caller can report its status
+			if i == attempts-1 {
+				return resp, nil
+			}
+			resp.Body.Close()
+			continue
+		}
+		return resp, nil
+	}
+	return nil, fmt.Errorf("%w (after %d attempts)", err, attempts)
+}
+
+// getVersion returns the module version when built by "go install".
+func getVersion() string {
+	if bi, ok := debug.ReadBuildInfo(); ok && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		return bi.Main.Version
+	}
+	return "devel"
+}
+
+// }}}
+
+/*
+decodeEventID {{{
+*/
+func decodeEventID(s string) (string, error) {
+	if is64HexString(s) {
+		return s, nil
+	}
+	// accept the NIP-21 form that "nostk nevent" prints
+	prefix, v, err := nip19.Decode(strings.TrimPrefix(s, "nostr:"))
+	if err != nil {
+		return "", err
+	}
+	switch prefix {
+	case "note":
+		return v.(string), nil
+	case "nevent":
+		return v.(nostr.EventPointer).ID, nil
+	}
+	return "", errors.New("Not an event id: " + s)
+}
+
+// }}}
+
+/*
+signEvent {{{
+*/
+// errDumpedUnsigned stops the command after --dump-unsigned printed the
+// event; main exits with status 0 for it.
+var errDumpedUnsigned = errors.New("Dumped the unsigned event")
+
+func signEvent(ev *nostr.Event, sk string) error {
+	if err := checkEvent(ev); err != nil {
+		return err
+	}
+	if !allowSecret && (containsNsec1(ev.Content) || containsHsec1(ev.Content, sk)) {
+		fmt.Println("!!! The content contains a private key. Nothing published.")
+		fmt.Println("!!! Use --allow-secret only if you really mean to publish it.")
+		return errors.New("Content contains a private key")
+	}
+	if signAs != "" {
+		pk, err := decodePubKey(signAs)
+		if err != nil {
+			return err
+		}
+		if pk != ev.PubKey {
+			fmt.Println("Your key does not match " + signAs + ". Nothing published.")
+			return errors.New("Signing key does not match --sign-as")
+		}
+	}
+	// created_at is corrected by eventNow already; a clock that cannot be
+	// checked stops here rather than signing with the local one
+	if fixClock {
+		if _, err := getClockSkew(); err != nil {
+			return err
+		}
+	}
+	if dumpUnsigned {
+		// show what the tag parsing produced and stop before signing
+		if err := printJSON(ev); err != nil {
+			return err
+		}
+		return errDumpedUnsigned
+	}
+	if cmd := os.Getenv("NOSTK_SIGNER_CMD"); cmd != "" {
+		return signEventExternal(ev, cmd)
+	}
+	// calling Sign sets the event ID field and the event Sig field
+	return ev.Sign(sk)
+}
+
+// eventNow is the created_at of new events: the local clock, corrected with
+// --fix-clock. Replaceable events clamp it to the copy on relays afterwards,
+// so the skew cannot take them back behind it.
+func eventNow() nostr.Timestamp {
+	now := nostr.Now()
+	if fixClock {
+		if skew, err := getClockSkew(); err == nil {
+			now += nostr.Timestamp(skew / time.Second)
+		}
+	}
+	return now
+}
+
+// clockSkew caches the result of getClockSkew for events signed later.
+var clockSkew *time.Duration
 
+// getClockSkew compares the local clock with the Date header of the first
+// relay in the list. The header has one second resolution, so the midpoint
+// of the request is used and skews up to a few seconds are ignored.
+func getClockSkew() (time.Duration, error) {
+	if clockSkew != nil {
+		return *clockSkew, nil
+	}
 	var rl []string
 	if err := getRelayList(&rl); err != nil {
 		fmt.Println("Nothing relay list. Make a relay list.")
-		return err
+		return 0, err
+	}
+	if len(rl) == 0 {
+		return 0, errors.New("Nothing relay to check the clock with")
+	}
+	u := strings.Replace(strings.Replace(rl[0], "wss://", "https://", 1), "ws://", "http://", 1)
+	start := time.Now()
+	resp, err := httpDo("HEAD", u)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	end := time.Now()
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, errors.New("Not found Date header on " + rl[0])
+	}
+	local := start.Add(end.Sub(start) / 2)
+	skew := date.Sub(local).Round(time.Second)
+	if skew >= -3*time.Second && skew <= 3*time.Second {
+		skew = 0
+	} else {
+		fmt.Fprintf(os.Stderr, "warning: local clock differs from %s by %s, correcting created_at\n", rl[0], skew)
 	}
+	clockSkew = &skew
+	return skew, nil
+}
 
-	ev := nostr.Event{
-		PubKey:    pk,
-		CreatedAt: nostr.Now(),
-		Kind:      nostr.KindRelayListMetadata,
-		Tags:      tags,
-		Content:   "",
+// checkEvent rejects events that relays would refuse or treat unpredictably.
+func checkEvent(ev *nostr.Event) error {
+	// parameterized replaceable events are addressed by their "d" tag
+	if ev.Kind >= 30000 && ev.Kind < 40000 && !hasTagKey(ev.Tags, "d") {
+		fmt.Printf("Kind %d needs a \"d\" tag, e.g. [\"d\", \"<identifier>\"]. Nothing published.\n", ev.Kind)
+		return errors.New("Missing d tag")
+	}
+	// most relays refuse large events, so a huge paste or a runaway tag
+	// parse is stopped here instead of at every relay
+	if len(ev.Content) > maxContent {
+		fmt.Printf("Content is %d bytes, over the limit of %d. Use --max-content to raise it.\n", len(ev.Content), maxContent)
+		return errors.New("Content too large")
+	}
+	if len(ev.Tags) > maxTags {
+		fmt.Printf("Event has %d tags, over the limit of %d. Use --max-tags to raise it.\n", len(ev.Tags), maxTags)
+		return errors.New("Too many tags")
 	}
+	return nil
+}
 
-	// calling Sign sets the event ID field and the event Sig field
-	ev.Sign(sk)
+var nsec1Regexp = regexp.MustCompile(`nsec1[023456789acdefghjklmnpqrstuvwxyz]{58}`)
 
-	// publish the event to two relays
-	ctx := context.Background()
-	for _, url := range rl {
-		relay, err := nostr.RelayConnect(ctx, url)
-		if err != nil {
-			fmt.Println(err)
-			continue
-		}
-		_, err = relay.Publish(ctx, ev)
-		if err != nil {
-			fmt.Println(err)
-			continue
+// containsNsec1 reports whether s has an nsec with a valid checksum, so that
+// a mistyped or made-up one does not block the message.
+func containsNsec1(s string) bool {
+	for _, m := range nsec1Regexp.FindAllString(strings.ToLower(s), -1) {
+		if prefix, _, err := nip19.Decode(m); err == nil && prefix == "nsec" {
+			return true
 		}
-		fmt.Printf("published relay list to %s\n", url)
 	}
-
-	return nil
+	return false
 }
-// }}}
 
-/*
-getDir {{{
-*/
-func getDir() (string, error) {
-	home := os.Getenv("HOME")
-	if home == "" {
-		return "", errors.New("Not set HOME environmental variables")
+// containsHsec1 reports whether s has the hex private key sk. Any other
+// 64 hex characters may as well be an event id or a public key. Keys made
+// by GeneratePrivateKey may lack leading zeros, so the key is matched both
+// as stored and padded to 64 characters.
+func containsHsec1(s string, sk string) bool {
+	if sk == "" {
+		return false
 	}
-	home += secretDir
-	if _, err := os.Stat(home); err != nil {
-		if err = os.Mkdir(home, 0700); err != nil {
-			return "", err
+	s = strings.ToLower(s)
+	sk = strings.ToLower(sk)
+	return strings.Contains(s, fmt.Sprintf("%064s", sk)) || strings.Contains(s, sk)
+}
+
+// hasTagKey reports whether a tag is named key. Tags.GetFirst cannot be
+// used, as it matches the name as a prefix.
+func hasTagKey(tags nostr.Tags, key string) bool {
+	for _, t := range tags {
+		if t.Key() == key {
+			return true
 		}
 	}
-	return home, nil
+	return false
 }
 
-// }}}
-
-/*
-getRelayList {{{
-*/
-func getRelayList(rl *[]string) error {
-	p := make(map[string]RwFlag)
-	b, err := readRelayList()
+// signEventExternal pipes the unsigned event as JSON into the signer command
+// and reads the signed event back from its standard output.
+func signEventExternal(ev *nostr.Event, cmd string) error {
+	ev.ID = ev.GetID()
+	b, err := json.Marshal(ev)
 	if err != nil {
 		return err
 	}
-	err = json.Unmarshal([]byte(b), &p)
+	c := exec.Command("sh", "-c", cmd)
+	c.Stdin = bytes.NewReader(b)
+	c.Stderr = os.Stderr
+	out, err := c.Output()
 	if err != nil {
-		return err
+		return fmt.Errorf("signer command failed: %w", err)
 	}
-	for i := range p {
-		*rl = append(*rl, i)
+	var sev nostr.Event
+	if err := json.Unmarshal(out, &sev); err != nil {
+		return fmt.Errorf("signer returned invalid event: %w", err)
 	}
+	if sev.GetID() != ev.ID {
+		return errors.New("Signer command changed the event")
+	}
+	if ok, err := sev.CheckSignature(); err != nil || !ok {
+		return errors.New("Signer command returned an invalid signature")
+	}
+	*ev = sev
 	return nil
 }
 
 // }}}
 
 /*
-saveRelays {{{
+	setCustomEmoji {{{
 */
-func saveRelays(rl []string) error {
-	dn, err := getDir()
-	if err != nil {
-		return err
+func setCustomEmoji(s string, tgs *nostr.Tags)error{
+	*tgs = nil
+	ts := make(map[string]string)
+	if err := getCustomEmoji(&ts);err!=nil {
+		// without customemoji.json there is nothing to look up
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return  err
 	}
-
-	path := dn + "/" + relays
-	fp, err := os.Create(path)
-	if err != nil {
-		return err
+	// any name in the file is looked up, non-ASCII ones too; tags follow
+	// the first use in s so that the event is the same from run to run
+	pos := make(map[string]int)
+	var names []string
+	for k := range ts {
+		if i := strings.Index(s, ":"+k+":"); k != "" && i >= 0 {
+			pos[k] = i
+			names = append(names, k)
+		}
 	}
-	defer fp.Close()
-	for _, l := range rl {
-		_, err = fp.WriteString(l + "\n")
-		if err != nil {
-			return err
+	sort.Slice(names, func(i, j int) bool {
+		if pos[names[i]] != pos[names[j]] {
+			return pos[names[i]] < pos[names[j]]
 		}
+		return names[i] < names[j]
+	})
+	for _, k := range names {
+		*tgs = append(*tgs, nostr.Tag{"emoji", k, ts[k]})
 	}
 	return nil
 }
-
 // }}}
 
 /*
-readPrivateKey {{{
+	setHashTags {{{
 */
-func readPrivateKey() (string, error) {
-	var k []string
-	dir, err := getDir()
-	if err != nil {
-		return "", err
-	}
-	path := dir + "/" + hsec
-	if _, err := os.Stat(path); err != nil {
-		return "", err
+// Hashtag bodies are matched with Unicode classes so that multibyte tags such
+// as "#日本語" are never split at a byte boundary. Full-width "＃" typed by
+// Japanese input methods and the small "﹟" are accepted as well. Only the
+// tags are changed; the content keeps its marks.
+var hashTagRegexp = regexp.MustCompile(`(?:^|[^\p{L}\p{N}\p{M}_&])[#＃﹟]([\p{L}\p{N}\p{M}_]+)`)
+
+func setHashTags(s string, tgs *nostr.Tags) {
+	seen := make(map[string]bool)
+	for _, t := range *tgs {
+		if t.Key() == "t" {
+			seen[t.Value()] = true
+		}
 	}
-	b, err := ioutil.ReadFile(path)
-	rs := strings.Split(string(b), "\n")
-	for _, r := range rs {
-		if r != "" { // 最終行の\nにより発生する余分なレコードを排除
-			k = append(k, r)
+	for _, m := range hashTagRegexp.FindAllStringSubmatch(s, -1) {
+		// "#Go" and "#go" are the same tag for relays
+		v := strings.ToLower(m[1])
+		if seen[v] {
+			continue
 		}
+		seen[v] = true
+		*tgs = append(*tgs, nostr.Tag{"t", v})
 	}
-	return k[0], nil
 }
+// }}}
 
+/*
+	setAlt {{{
+*/
+// setAlt adds a NIP-31 alt tag so that clients which do not know the kind
+// can still show a short description of the event.
+func setAlt(s string, tgs *nostr.Tags) {
+	*tgs = append(*tgs, nostr.Tag{"alt", s})
+}
 // }}}
 
 /*
-	setCustomEmoji {{{
+	setContentWarning {{{
 */
-func setCustomEmoji(s string, tgs *nostr.Tags)error{
-	*tgs = nil
-	ts := make(map[string]string)
-	if err := getCustomEmoji(&ts);err!=nil {
-		return  err
-	}
-	var t []string
-	for i:=range ts {
-		if strings.Contains(s, ":"+i+":") {
-			t = nil
-			t = append(t, "emoji")
-			t = append(t, i)
-			t = append(t, ts[i])
-			*tgs = append(*tgs, t)
-		}
-	}
-	return nil
+// setContentWarning adds a NIP-36 content-warning tag; the reason may be
+// empty.
+func setContentWarning(reason string, tgs *nostr.Tags) {
+	*tgs = append(*tgs, nostr.Tag{"content-warning", reason})
 }
 // }}}
 
@@ -693,6 +6280,9 @@ func getCustomEmoji(ts *map[string]string) error {
 	if err!=nil {
 		return err
 	}
+	if strings.TrimSpace(b) == "" {
+		return nil
+	}
 	err = json.Unmarshal([]byte(b), ts)
 	if err != nil {
 		return err
@@ -735,7 +6325,35 @@ createRelayList {{{
 */
 func createRelayList() error {
 	p := make(map[string]RwFlag)
-	p[""] = RwFlag{true, true}
+	p[""] = RwFlag{true, true, nil}
+	s, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	d, err := getDir()
+	if err != nil {
+		return err
+	}
+	path := d + "/" + relays
+	fp, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	_, err = fp.WriteString(string(s))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// }}}
+
+/*
+writeRelayList {{{
+*/
+func writeRelayList(p map[string]RwFlag) error {
 	s, err := json.Marshal(p)
 	if err != nil {
 		return err
@@ -791,6 +6409,34 @@ func createCustomEmojiList() error {
 
 // }}}
 
+/*
+writeCustomEmojiList {{{
+*/
+func writeCustomEmojiList(ts map[string]string) error {
+	s, err := json.Marshal(ts)
+	if err != nil {
+		return err
+	}
+
+	d, err := getDir()
+	if err != nil {
+		return err
+	}
+	path := d + "/" + emoji
+	fp, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	_, err = fp.WriteString(string(s))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// }}}
+
 /*
 readProfile {{{
 */
@@ -855,6 +6501,259 @@ func readCustomEmojiList() (string, error) {
 
 //}}}
 
+/*
+getOption {{{
+*/
+// getOption removes "name value" from args and returns the value.
+func getOption(args *[]string, name string) (string, bool) {
+	for i, a := range *args {
+		if a == name && i+1 < len(*args) {
+			v := (*args)[i+1]
+			*args = append((*args)[:i:i], (*args)[i+2:]...)
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// hasOption removes the flag name from args and reports whether it was set.
+func hasOption(args *[]string, name string) bool {
+	for i, a := range *args {
+		if a == name {
+			*args = append((*args)[:i:i], (*args)[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// }}}
+
+/*
+setMessageOptions {{{
+*/
+// setMessageOptions consumes the tag options shared by pubMessage and draft.
+func setMessageOptions(args *[]string, tgs *nostr.Tags) error {
+	if alt, ok := getOption(args, "--alt"); ok {
+		setAlt(alt, tgs)
+	}
+	// the reason of --cw is optional, so the word after it is the reason
+	// only when something is left to be the message
+	for i, a := range *args {
+		if a != "--cw" {
+			continue
+		}
+		reason := ""
+		n := i + 1
+		if n < len(*args)-1 && !strings.HasPrefix((*args)[n], "-") {
+			reason = (*args)[n]
+			n++
+		}
+		*args = append((*args)[:i:i], (*args)[n:]...)
+		setContentWarning(reason, tgs)
+		break
+	}
+	if g, ok := getOption(args, "--geohash"); ok {
+		g = strings.ToLower(g)
+		if !geohashRegexp.MatchString(g) {
+			return errors.New("Invalid geohash: " + g)
+		}
+		*tgs = append(*tgs, nostr.Tag{"g", g})
+	}
+	if loc, ok := getOption(args, "--location"); ok {
+		g, err := parseLocation(loc)
+		if err != nil {
+			return err
+		}
+		*tgs = append(*tgs, nostr.Tag{"g", g})
+	}
+	fetch := hasOption(args, "--imeta-fetch")
+	for {
+		u, ok := getOption(args, "--imeta")
+		if !ok {
+			break
+		}
+		t, err := makeImeta(u, fetch)
+		if err != nil {
+			return err
+		}
+		*tgs = append(*tgs, t)
+	}
+	return nil
+}
+
+// makeImeta builds a NIP-92 imeta tag for the media URL. The mime type is
+// guessed from the extension unless fetch is set, in which case the media
+// is downloaded for its type, sha256 and, for images, dimensions.
+func makeImeta(u string, fetch bool) (nostr.Tag, error) {
+	pu, err := url.Parse(u)
+	if err != nil || (pu.Scheme != "https" && pu.Scheme != "http") {
+		return nil, errors.New("Invalid media URL: " + u)
+	}
+	t := nostr.Tag{"imeta", "url " + u}
+	if !fetch {
+		if m := mime.TypeByExtension(path.Ext(pu.Path)); m != "" {
+			t = append(t, "m "+strings.Split(m, ";")[0])
+		}
+		return t, nil
+	}
+
+	resp, err := httpDo("GET", u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", u, resp.Status)
+	}
+	// only the head is kept for the type and dimensions, the rest is
+	// streamed through the hash
+	h := sha256.New()
+	head := &headBuffer{n: imetaHeadSize}
+	n, err := io.Copy(io.MultiWriter(h, head), io.LimitReader(resp.Body, maxImetaSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > maxImetaSize {
+		return nil, fmt.Errorf("%s is larger than %d MiB", u, maxImetaSize>>20)
+	}
+	m := strings.Split(resp.Header.Get("Content-Type"), ";")[0]
+	if m == "" || m == "application/octet-stream" {
+		m = strings.Split(http.DetectContentType(head.Bytes()), ";")[0]
+	}
+	t = append(t, "m "+m)
+	t = append(t, "x "+hex.EncodeToString(h.Sum(nil)))
+	if c, _, err := image.DecodeConfig(bytes.NewReader(head.Bytes())); err == nil {
+		t = append(t, fmt.Sprintf("dim %dx%d", c.Width, c.Height))
+	}
+	return t, nil
+}
+
+const (
+	maxImetaSize  = 100 << 20
+	imetaHeadSize = 1 << 20
+)
+
+// headBuffer keeps the first n bytes written and discards the rest.
+type headBuffer struct {
+	bytes.Buffer
+	n int
+}
+
+func (b *headBuffer) Write(p []byte) (int, error) {
+	if r := b.n - b.Len(); r > 0 {
+		if r > len(p) {
+			r = len(p)
+		}
+		b.Buffer.Write(p[:r])
+	}
+	return len(p), nil
+}
+
+const geohashBase32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+var geohashRegexp = regexp.MustCompile("^[" + geohashBase32 + "]{1,12}$")
+
+// parseLocation turns "lat,lon" into a geohash of 9 characters, which is
+// a cell of a few meters.
+func parseLocation(s string) (string, error) {
+	ls, rs, ok := strings.Cut(s, ",")
+	if !ok {
+		return "", errors.New("Location must be lat,lon: " + s)
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(ls), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return "", errors.New("Invalid latitude: " + ls)
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(rs), 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return "", errors.New("Invalid longitude: " + rs)
+	}
+	return encodeGeohash(lat, lon, 9), nil
+}
+
+func encodeGeohash(lat float64, lon float64, precision int) string {
+	latR := [2]float64{-90, 90}
+	lonR := [2]float64{-180, 180}
+	var sb strings.Builder
+	even := true
+	bit, ch := 0, 0
+	for sb.Len() < precision {
+		// bits alternate between longitude and latitude, longitude first
+		r, v := &latR, lat
+		if even {
+			r, v = &lonR, lon
+		}
+		mid := (r[0] + r[1]) / 2
+		ch <<= 1
+		if v >= mid {
+			ch |= 1
+			r[0] = mid
+		} else {
+			r[1] = mid
+		}
+		even = !even
+		if bit++; bit == 5 {
+			sb.WriteByte(geohashBase32[ch])
+			bit, ch = 0, 0
+		}
+	}
+	return sb.String()
+}
+
+// }}}
+
+/*
+readMessage {{{
+*/
+// readMessage takes the note body from --file, the first argument or stdin.
+func readMessage(args *[]string) (string, error) {
+	if path, ok := getOption(args, "--file"); ok {
+		return readMessageFile(path)
+	}
+	if len(*args) > 0 && (*args)[0] == "-" {
+		// read until EOF with no time limit, keeping every byte as is
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return "", err
+		}
+		if strings.TrimSpace(string(b)) == "" {
+			fmt.Println("Nothing text message.")
+			return "", errors.New("Not set text message")
+		}
+		return string(b), nil
+	}
+	if len(*args) > 0 {
+		return (*args)[0], nil
+	}
+	buff, err := readStdIn()
+	if err != nil {
+		fmt.Println("Nothing text message.")
+		return "", errors.New("Not set text message")
+	}
+	return buff, nil
+}
+
+// }}}
+
+/*
+readMessageFile {{{
+*/
+func readMessageFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Println("Not found message file: " + path)
+		return "", err
+	}
+	if strings.TrimSpace(string(b)) == "" {
+		fmt.Println("Nothing text message.")
+		return "", errors.New("Message file is empty: " + path)
+	}
+	return string(b), nil
+}
+
+// }}}
+
 /*
   readStdIn {{{
 */
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+func TestSetHashTags(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		tags nostr.Tags
+		want []string
+	}{
+		{"ascii", "hello #nostr", nil, []string{"nostr"}},
+		{"japanese", "今日は #日本語 の日", nil, []string{"日本語"}},
+		{"fullwidth sign", "＃全角 と ﹟小さい", nil, []string{"全角", "小さい"}},
+		{"case folded", "#Go and #go", nil, []string{"go"}},
+		{"prefix of another", "#golang #go", nil, []string{"golang", "go"}},
+		{"trailing punctuation", "#go, #nostr. #日本語！", nil, []string{"go", "nostr", "日本語"}},
+		{"emoji ends tag", "#party🎉", nil, []string{"party"}},
+		{"emoji only", "#🎉", nil, nil},
+		{"inside word", "a#b", nil, nil},
+		{"html entity", "&#123;", nil, nil},
+		{"already tagged", "#Go", nostr.Tags{{"t", "go"}}, []string{"go"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tgs := tt.tags
+			setHashTags(tt.s, &tgs)
+			var got []string
+			for _, tg := range tgs {
+				if tg.Key() == "t" {
+					got = append(got, tg.Value())
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("setHashTags(%q) = %q, want %q", tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHashTagRegexp(t *testing.T) {
+	tests := []struct {
+		s    string
+		want bool
+	}{
+		{"#nostr", true},
+		{"(#nostr)", true},
+		{"#日本語", true},
+		{"#", false},
+		{"# nostr", false},
+		{"x#nostr", false},
+		{"_#nostr", false},
+	}
+	for _, tt := range tests {
+		if got := hashTagRegexp.MatchString(tt.s); got != tt.want {
+			t.Errorf("hashTagRegexp.MatchString(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
+
+// testHome points getDir at a fresh directory holding the given files.
+func testHome(t *testing.T, files map[string]string) string {
+	t.Helper()
+	d := t.TempDir()
+	t.Setenv("NOSTK_HOME", d)
+	for name, content := range files {
+		if err := os.WriteFile(d+"/"+name, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return d
+}
+
+func TestCheckWriteRelays(t *testing.T) {
+	tests := []struct {
+		name    string
+		relays  string
+		wantErr bool
+	}{
+		{"empty file", "", true},
+		{"empty list", "{}", true},
+		{"read only", `{"wss://a.example":{"read":true,"write":false},"wss://b.example":{"read":true,"write":false}}`, true},
+		{"one writable", `{"wss://a.example":{"read":true,"write":false},"wss://b.example":{"read":false,"write":true}}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testHome(t, map[string]string{relays: tt.relays})
+			err := checkWriteRelays()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkWriteRelays() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestContainsNsec1(t *testing.T) {
+	nsec, err := nip19.EncodePrivateKey(nostr.GeneratePrivateKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	npub, err := nip19.EncodePublicKey(nostr.GeneratePrivateKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// change the last character so that only the checksum is broken
+	last := nsec[len(nsec)-1]
+	broken := nsec[:len(nsec)-1] + "q"
+	if last == 'q' {
+		broken = nsec[:len(nsec)-1] + "p"
+	}
+
+	tests := []struct {
+		name string
+		s    string
+		want bool
+	}{
+		{"alone", nsec, true},
+		{"in a note", "my key is " + nsec + " oops", true},
+		{"in a URL", "https://example.com/login?key=" + nsec + "&x=1", true},
+		{"inside a word", "abc" + nsec + "xyz", true},
+		{"nostr URI", "nostr:" + nsec, true},
+		// bech32 is case-insensitive, so this still is the key
+		{"uppercase", strings.ToUpper(nsec), true},
+		{"wrong checksum", broken, false},
+		{"truncated", nsec[:len(nsec)-1], false},
+		{"npub", npub, false},
+		{"prefix only", "nsec1", false},
+		{"made up", "nsec1" + strings.Repeat("q", 58), false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := containsNsec1(tt.s); got != tt.want {
+				t.Errorf("containsNsec1(%q) = %v, want %v", tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckEventLimits(t *testing.T) {
+	tags := func(n int) nostr.Tags {
+		tgs := make(nostr.Tags, n)
+		for i := range tgs {
+			tgs[i] = nostr.Tag{"t", "x"}
+		}
+		return tgs
+	}
+	tests := []struct {
+		name    string
+		ev      nostr.Event
+		wantErr bool
+	}{
+		{"content at limit", nostr.Event{Kind: 1, Content: strings.Repeat("a", maxContent)}, false},
+		{"content over limit", nostr.Event{Kind: 1, Content: strings.Repeat("a", maxContent+1)}, true},
+		// the limit is in bytes, not characters
+		{"multibyte content over limit", nostr.Event{Kind: 1, Content: strings.Repeat("あ", maxContent/3+1)}, true},
+		{"tags at limit", nostr.Event{Kind: 1, Tags: tags(maxTags)}, false},
+		{"tags over limit", nostr.Event{Kind: 1, Tags: tags(maxTags + 1)}, true},
+		{"addressable without d", nostr.Event{Kind: 30023}, true},
+		{"addressable with d", nostr.Event{Kind: 30023, Tags: nostr.Tags{{"d", "x"}}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkEvent(&tt.ev)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkEvent() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPublishRelayListSkipsSeed(t *testing.T) {
+	testHome(t, nil)
+	// relays.json as init leaves it
+	if err := createRelayList(); err != nil {
+		t.Fatal(err)
+	}
+	if err := publishRelayList(); err == nil {
+		t.Fatal("publishRelayList() published a relay list with only the empty seed entry")
+	}
+
+	testHome(t, map[string]string{
+		relays: `{"":{"read":true,"write":true},"wss://b.example":{"read":true,"write":false},"wss://a.example":{"read":true,"write":true}}`,
+	})
+	p := make(map[string]RwFlag)
+	if err := getRelayMap(p); err != nil {
+		t.Fatal(err)
+	}
+	want := nostr.Tags{{"r", "wss://a.example"}, {"r", "wss://b.example", "read"}}
+	if got := relayListTags(p); !reflect.DeepEqual(got, want) {
+		t.Errorf("relayListTags() = %v, want %v", got, want)
+	}
+}
+
+func TestReadPrivateKeyEmpty(t *testing.T) {
+	for name, content := range map[string]string{
+		"empty":           "",
+		"whitespace only": " \n\t\n\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			testHome(t, map[string]string{hsec: content})
+			sk, err := readPrivateKey()
+			if err == nil || !strings.Contains(err.Error(), "Private key file is empty") {
+				t.Errorf("readPrivateKey() = %q, %v, want the empty key file error", sk, err)
+			}
+		})
+	}
+}
+
+func TestPublishedEvents(t *testing.T) {
+	testHome(t, map[string]string{emoji: `{"wave":"https://e.example/wave.png"}`})
+	pk := strings.Repeat("ab", 32)
+
+	msg, err := messageEvent(pk, "hi :wave: #Nostr", nostr.Tags{{"p", pk}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		ev   nostr.Event
+		kind int
+		tags nostr.Tags
+	}{
+		{
+			"pubMessage", msg, nostr.KindTextNote,
+			nostr.Tags{{"emoji", "wave", "https://e.example/wave.png"}, {"t", "nostr"}, {"p", pk}},
+		},
+		{
+			"pubProfile", profileEvent(pk, `{"name":"a"}`, 1), nostr.KindSetMetadata,
+			nil,
+		},
+		{
+			"pubRelays", relayListEvent(pk, relayListTags(map[string]RwFlag{
+				"wss://a.example": {true, true, nil},
+				"wss://w.example": {false, true, nil},
+			}), 1), nostr.KindRelayListMetadata,
+			nostr.Tags{{"r", "wss://a.example"}, {"r", "wss://w.example", "write"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.ev.Kind != tt.kind {
+				t.Errorf("Kind = %d, want %d", tt.ev.Kind, tt.kind)
+			}
+			if !reflect.DeepEqual(tt.ev.Tags, tt.tags) {
+				t.Errorf("Tags = %v, want %v", tt.ev.Tags, tt.tags)
+			}
+			if tt.ev.PubKey != pk {
+				t.Errorf("PubKey = %s, want %s", tt.ev.PubKey, pk)
+			}
+		})
+	}
+}
+
+func TestContainsHsec1(t *testing.T) {
+	sk := "7f4c11a9b0c395e7a0cad5f3e1b2f42f7e8a3c94d7a8e1f6b2c3d4e5f6a7b8c9"
+	// one with its leading zero dropped, as GeneratePrivateKey can return
+	short := "f4c11a9b0c395e7a0cad5f3e1b2f42f7e8a3c94d7a8e1f6b2c3d4e5f6a7b8c9"
+	other := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	tests := []struct {
+		name string
+		s    string
+		sk   string
+		want bool
+	}{
+		{"own key", sk, sk, true},
+		{"uppercase", strings.ToUpper(sk), sk, true},
+		{"in text", "oops: " + sk + " (do not share)", sk, true},
+		{"short key as stored", "key " + short, short, true},
+		{"short key padded", "key 0" + short, short, true},
+		{"unrelated hex", other, sk, false},
+		{"part of own key", sk[:63], sk, false},
+		{"empty", "", sk, false},
+		{"no private key", sk, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := containsHsec1(tt.s, tt.sk); got != tt.want {
+				t.Errorf("containsHsec1(%q, %q) = %v, want %v", tt.s, tt.sk, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignEventRefusesSecret(t *testing.T) {
+	t.Setenv("NOSTK_SIGNER_CMD", "")
+	sk := nostr.GeneratePrivateKey()
+	pk, err := nostr.GetPublicKey(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nsec, err := nip19.EncodePrivateKey(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{"hex key", "my key " + sk, true},
+		{"nsec", "my key " + nsec, true},
+		// event ids and public keys are 64 hex characters too
+		{"unrelated hex", "see " + nostr.GeneratePrivateKey(), false},
+		{"own public key", "I am " + pk, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev := nostr.Event{PubKey: pk, CreatedAt: nostr.Now(), Kind: nostr.KindTextNote, Content: tt.content}
+			err := signEvent(&ev, sk)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("signEvent() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSetCustomEmoji(t *testing.T) {
+	testHome(t, map[string]string{emoji: `{"wave":"https://e.example/wave.png","ねこ":"https://e.example/neko.png","+1":"https://e.example/plus.png","nope":"https://e.example/nope.png"}`})
+	tests := []struct {
+		name string
+		s    string
+		want nostr.Tags
+	}{
+		{"ascii", "hi :wave:", nostr.Tags{{"emoji", "wave", "https://e.example/wave.png"}}},
+		{"japanese", "かわいい:ねこ:", nostr.Tags{{"emoji", "ねこ", "https://e.example/neko.png"}}},
+		{"symbol", "agree :+1:", nostr.Tags{{"emoji", "+1", "https://e.example/plus.png"}}},
+		{"order of use and dedup", ":ねこ: :wave: :ねこ: :wave:", nostr.Tags{
+			{"emoji", "ねこ", "https://e.example/neko.png"},
+			{"emoji", "wave", "https://e.example/wave.png"},
+		}},
+		{"unknown skipped", ":unknown: :wave:", nostr.Tags{{"emoji", "wave", "https://e.example/wave.png"}}},
+		{"not a shortcode", "wave nope:", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tgs nostr.Tags
+			if err := setCustomEmoji(tt.s, &tgs); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tgs, tt.want) {
+				t.Errorf("setCustomEmoji(%q) = %v, want %v", tt.s, tgs, tt.want)
+			}
+		})
+	}
+
+	// without customemoji.json nothing is tagged and nothing fails
+	testHome(t, nil)
+	var tgs nostr.Tags
+	if err := setCustomEmoji(":wave:", &tgs); err != nil || tgs != nil {
+		t.Errorf("setCustomEmoji without emoji file = %v, %v", tgs, err)
+	}
+}
+
+func TestSignEventDumpUnsigned(t *testing.T) {
+	t.Setenv("NOSTK_SIGNER_CMD", "")
+	dumpUnsigned = true
+	defer func() { dumpUnsigned = false }()
+	sk := nostr.GeneratePrivateKey()
+	pk, err := nostr.GetPublicKey(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := nostr.Event{PubKey: pk, CreatedAt: nostr.Now(), Kind: nostr.KindTextNote, Content: "hi"}
+	if err := signEvent(&ev, sk); !errors.Is(err, errDumpedUnsigned) {
+		t.Errorf("signEvent() = %v, want errDumpedUnsigned", err)
+	}
+	if ev.Sig != "" {
+		t.Errorf("signEvent() signed the event with --dump-unsigned")
+	}
+}
+
+func TestWithRelayHints(t *testing.T) {
+	got := withRelayHints(
+		[]string{"wss://Hint.example/", "http://bad.example", "not a url", "", "wss://mine.example"},
+		[]string{"wss://mine.example", "wss://other.example"},
+	)
+	want := []string{"wss://hint.example", "wss://mine.example", "wss://other.example"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("withRelayHints() = %q, want %q", got, want)
+	}
+}